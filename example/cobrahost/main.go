@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aligator/goplug/example/host/actions"
+	"github.com/aligator/goplug/example/host/api"
+	"github.com/aligator/goplug/goplug"
+	"github.com/aligator/goplug/goplug/cobrahost"
+	"github.com/spf13/cobra"
+)
+
+// This host does the same as example/host but uses cobra for the
+// subcommands of the plugins.
+func main() {
+	root := &cobra.Command{
+		Use:   "cobrahost",
+		Short: "An example host which provides the plugins as subcommands",
+	}
+
+	h := cobrahost.New(root)
+
+	app := api.App{}
+
+	g := goplug.GoPlug{
+		PluginFolder: "./example/plugin-bin",
+		ConfigFile:   "./example/plugins.yaml",
+		Host:         h,
+		Actions: &actions.HostActions{
+			Api0AppRef: &app,
+		},
+	}
+	h.GoPlug = &g
+
+	report, err := g.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, failure := range report.Failed {
+		fmt.Println("could not load plugin:", failure)
+	}
+
+	err = root.Execute()
+
+	closeErr := g.Close()
+	if closeErr != nil {
+		fmt.Println("could not shut down the plugins:", closeErr)
+	}
+
+	os.Exit(cobrahost.ExitCode(err))
+}
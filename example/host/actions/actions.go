@@ -4,18 +4,53 @@ import (
 	api0 "github.com/aligator/goplug/example/host/api"
 	apackage0 "github.com/aligator/goplug/example/host/api/a_package"
 	"github.com/aligator/goplug/goplug"
+	io0 "io"
+)
+
+const (
+	// HostAPIVersion is the version of the host API.
+	HostAPIVersion = "1.0.0"
+
+	// ActionsHash is a hash of the signatures of all actions.
+	ActionsHash = "e304f30baaa7dbf4"
 )
 
 // HostActions contains the host-implementations of actions.
 type HostActions struct {
 	Api0AppRef *api0.App
+
+	// streams contains the streams of the calling plugin.
+	// It is set by BindStreams.
+	streams *goplug.StreamRegistry
+}
+
+// BindStreams returns a copy of the actions which uses the streams of one
+// plugin process. It is called by GoPlug for each started plugin.
+func (h *HostActions) BindStreams(streams *goplug.StreamRegistry) interface{} {
+	bound := *h
+	bound.streams = streams
+	return &bound
+}
+
+// APIInfo returns the version of the host API.
+// It is used by GoPlug to check if plugins are compatible.
+func (h *HostActions) APIInfo() goplug.APIInfo {
+	return goplug.APIInfo{
+		Version:     HostAPIVersion,
+		ActionsHash: ActionsHash,
+	}
 }
 
 type ClientActions struct {
 	client *goplug.Client
 }
 
+// NewClientActions creates the actions for the plugin.
+// It also stamps the host API the plugin is built against into it.
 func NewClientActions(plugin *goplug.Client) ClientActions {
+	plugin.HostAPIVersion = HostAPIVersion
+	plugin.ActionsHash = ActionsHash
+
 	return ClientActions{
 		client: plugin,
 	}
@@ -27,6 +62,62 @@ func (c *ClientActions) Print(text string) error {
 	return c.client.Print(text)
 }
 
+func (c *ClientActions) StorageGet(key string) ([]byte, error) {
+	return c.client.StorageGet(key)
+}
+
+func (c *ClientActions) StorageSet(key string, value []byte) error {
+	return c.client.StorageSet(key, value)
+}
+
+func (c *ClientActions) StorageDelete(key string) error {
+	return c.client.StorageDelete(key)
+}
+
+func (c *ClientActions) StorageList(prefix string) ([]string, error) {
+	return c.client.StorageList(prefix)
+}
+
+// Typed access to the metadata.
+
+// Metadata returns the typed metadata of the plugin.
+// It can be used by the host, e.g. in Host.RegisterOneShot.
+func Metadata(info goplug.PluginInfo) (api0.TestMetadata, error) {
+	var metadata api0.TestMetadata
+	err := info.DecodeMetadata(&metadata)
+	return metadata, err
+}
+
+// SetMetadata sets the typed metadata of the plugin.
+// It has to be called before Init.
+func (c *ClientActions) SetMetadata(metadata api0.TestMetadata) error {
+	return c.client.EncodeMetadata(metadata)
+}
+
+// Metadata returns the typed metadata of the plugin.
+func (c *ClientActions) Metadata() (api0.TestMetadata, error) {
+	return Metadata(c.client.PluginInfo)
+}
+
+// EntryPointMetadata returns the typed metadata of an entry point.
+// It can be used by the host, e.g. in EntryPointHost.RegisterEntryPoint.
+func EntryPointMetadata(entry goplug.EntryPoint) (api0.TestMetadata, error) {
+	var metadata api0.TestMetadata
+	err := entry.DecodeMetadata(&metadata)
+	return metadata, err
+}
+
+// AddEntryPoint adds an entry point with typed metadata to the plugin.
+// It has to be called before Init.
+func (c *ClientActions) AddEntryPoint(entry goplug.EntryPoint, metadata api0.TestMetadata, handler goplug.EntryPointHandler) error {
+	err := entry.EncodeMetadata(metadata)
+	if err != nil {
+		return err
+	}
+
+	return c.client.AddEntryPoint(entry, handler)
+}
+
 // Action implementations for host and client.
 
 type GetRandomIntRequest struct {
@@ -310,3 +401,166 @@ func (c *ClientActions) WithSliceToStructFromPackage(
 	}, &response)
 	return response.Res0, err
 }
+
+type CountBytesRequest struct {
+	R goplug.StreamID `json:"r"`
+}
+
+type CountBytesResponse struct {
+	Res0 int `json:"res0"`
+}
+
+// CountBytes reads everything from the reader and returns the amount of bytes.
+// The data is transferred as a stream, so it can be very big.
+func (h *HostActions) CountBytes(args CountBytesRequest, reply *CountBytesResponse) error {
+	// Host implementation.
+	r, err := h.streams.Reader(args.R)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	res0, err := h.Api0AppRef.CountBytes(
+		r,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = CountBytesResponse{
+		Res0: res0,
+	}
+
+	return nil
+}
+
+// CountBytes reads everything from the reader and returns the amount of bytes.
+// The data is transferred as a stream, so it can be very big.
+func (c *ClientActions) CountBytes(
+	r io0.Reader,
+) (res0 int, err error) {
+	// Calling from the plugin.
+	rTransfer, err := c.client.SendStream(r)
+	if err != nil {
+		return res0, err
+	}
+	defer rTransfer.Close()
+
+	response := CountBytesResponse{}
+	err = c.client.Call("CountBytes", CountBytesRequest{
+		R: rTransfer.ID,
+	}, &response)
+	if err == nil {
+		err = rTransfer.Wait()
+	}
+	return response.Res0, err
+}
+
+type WriteHelloRequest struct {
+	Name string          `json:"name"`
+	W    goplug.StreamID `json:"w"`
+}
+
+type WriteHelloResponse struct {
+}
+
+// WriteHello writes a greeting to the writer.
+func (h *HostActions) WriteHello(args WriteHelloRequest, reply *WriteHelloResponse) error {
+	// Host implementation.
+	w, err := h.streams.Writer(args.W)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	err = h.Api0AppRef.WriteHello(
+		args.Name,
+		w,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteHello writes a greeting to the writer.
+func (c *ClientActions) WriteHello(
+	name string,
+	w io0.Writer,
+) (err error) {
+	// Calling from the plugin.
+	wTransfer, err := c.client.ReceiveStream(w)
+	if err != nil {
+		return err
+	}
+	defer wTransfer.Close()
+
+	response := WriteHelloResponse{}
+	err = c.client.Call("WriteHello", WriteHelloRequest{
+		Name: name,
+		W:    wTransfer.ID,
+	}, &response)
+	if err == nil {
+		err = wTransfer.Wait()
+	}
+	return err
+}
+
+type ReverseRequest struct {
+	Data goplug.StreamID `json:"data"`
+}
+
+type ReverseResponse struct {
+	Res0 goplug.StreamID `json:"res0"`
+}
+
+// Reverse returns the reversed data.
+func (h *HostActions) Reverse(args ReverseRequest, reply *ReverseResponse) error {
+	// Host implementation.
+	data, err := h.streams.ReadAll(args.Data)
+	if err != nil {
+		return err
+	}
+
+	res0, err := h.Api0AppRef.Reverse(
+		data,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = ReverseResponse{
+		Res0: h.streams.ServeBytes(res0),
+	}
+
+	return nil
+}
+
+// Reverse returns the reversed data.
+func (c *ClientActions) Reverse(
+	data []byte,
+) (res0 []byte, err error) {
+	// Calling from the plugin.
+	dataTransfer, err := c.client.SendBytes(data)
+	if err != nil {
+		return res0, err
+	}
+	defer dataTransfer.Close()
+
+	response := ReverseResponse{}
+	err = c.client.Call("Reverse", ReverseRequest{
+		Data: dataTransfer.ID,
+	}, &response)
+	if err == nil {
+		err = dataTransfer.Wait()
+	}
+	if err != nil {
+		return res0, err
+	}
+	res0, err = c.client.PullBytes(response.Res0)
+	return res0, err
+}
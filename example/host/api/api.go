@@ -4,11 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aligator/goplug/example/host/api/a_package"
+	"io"
 	"math/rand"
 	"strconv"
 	"time"
 )
 
+// TestMetadata is the metadata each plugin sends to the host.
+//goplug:metadata
+type TestMetadata struct {
+	Command string `json:"command"`
+}
+
 type App struct {
 	isSeeded  bool
 	lastHello int
@@ -76,3 +83,28 @@ func (a App) WithPointerToStructFromPackage(val *apackage.AStruct) (*apackage.AS
 func (a App) WithSliceToStructFromPackage(val []apackage.AStruct) ([]apackage.AStruct, error) {
 	panic("not implemented")
 }
+
+// CountBytes reads everything from the reader and returns the amount of bytes.
+// The data is transferred as a stream, so it can be very big.
+//goplug:generate
+func (a *App) CountBytes(r io.Reader) (int, error) {
+	n, err := io.Copy(io.Discard, r)
+	return int(n), err
+}
+
+// WriteHello writes a greeting to the writer.
+//goplug:generate
+func (a *App) WriteHello(name string, w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Hello %v\n", name)
+	return err
+}
+
+// Reverse returns the reversed data.
+//goplug:generate
+func (a *App) Reverse(data []byte) ([]byte, error) {
+	res := make([]byte, len(data))
+	for i, b := range data {
+		res[len(data)-1-i] = b
+	}
+	return res, nil
+}
@@ -1,31 +1,45 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/aligator/goplug/example/host/actions"
 	"github.com/aligator/goplug/example/host/api"
-
-	"github.com/aligator/goplug/example/host/plugin"
 	"github.com/aligator/goplug/goplug"
 )
 
 type TestHost struct {
-	commands map[string]goplug.OnOneShot
+	commands   map[string]goplug.OnOneShot
+	completers map[string]goplug.Completer
+	plug       *goplug.GoPlug
 }
 
+// RegisterOneShot needs no locking, as goplug never calls the registration
+// methods concurrently.
 func (h TestHost) RegisterOneShot(info goplug.PluginInfo, action goplug.OnOneShot) error {
-	meta := new(plugin.TestMetadata)
-	err := json.Unmarshal([]byte(info.Metadata), meta)
+	meta, err := actions.Metadata(info)
+	if err != nil {
+		return err
+	}
+
+	h.commands[meta.Command] = action
+	return nil
+}
+
+// RegisterEntryPoint registers each subcommand of a plugin.
+func (h TestHost) RegisterEntryPoint(info goplug.PluginInfo, entry goplug.EntryPoint, action goplug.OnOneShot) error {
+	meta, err := actions.EntryPointMetadata(entry)
 	if err != nil {
 		return err
 	}
 
 	h.commands[meta.Command] = action
+	h.completers[meta.Command] = h.plug.Completer(info.ID, entry.Name)
 	return nil
 }
 
@@ -34,30 +48,102 @@ func main() {
 
 	h := new(TestHost)
 	h.commands = make(map[string]goplug.OnOneShot)
+	h.completers = make(map[string]goplug.Completer)
 
 	app := api.App{}
 
 	g := goplug.GoPlug{
 		PluginFolder: "./example/plugin-bin",
+		ConfigFile:   "./example/plugins.yaml",
 		Host:         h,
 		Actions: &actions.HostActions{
 			Api0AppRef: &app,
 		},
 	}
 
-	err := g.Init()
+	h.plug = &g
+
+	report, err := g.Init()
 	if err != nil {
 		panic(err)
 	}
 
+	// Plugins which could not be loaded are just not available.
+	for _, failure := range report.Failed {
+		fmt.Println("could not load plugin:", failure)
+	}
+
+	// Stop the listeners gracefully when done.
+	defer func() {
+		err := g.Close()
+		if err != nil {
+			fmt.Println("could not shut down the plugins:", err)
+		}
+	}()
+
 	// Some built in function...
 	if len(os.Args) == 1 {
 		fmt.Println("no command provided")
 		return
 	}
 
+	if os.Args[1] == "plugins" {
+		// List all plugins.
+		for _, p := range g.Plugins() {
+			fmt.Printf("%-16v %-8v %-10v %-10v %v\n", p.ID, p.Version, p.PluginType, p.State, p.Path)
+			if p.Description != "" {
+				fmt.Printf("  %v: %v\n", p.Name, p.Description)
+			}
+			if p.LastError != nil {
+				fmt.Println("  error:", p.LastError)
+			}
+		}
+		return
+	}
+
+	if os.Args[1] == "completion" {
+		// Print the bash completion script.
+		fmt.Print(goplug.BashCompletionScript("host"))
+		return
+	}
+
+	if os.Args[1] == goplug.CompleteCommand {
+		// Complete the command or its arguments.
+		args := os.Args[2:]
+		if len(args) <= 1 {
+			var completions []goplug.Completion
+			for key := range h.commands {
+				completions = append(completions, goplug.Completion{Value: key})
+			}
+			sort.Slice(completions, func(i, j int) bool {
+				return completions[i].Value < completions[j].Value
+			})
+			_ = goplug.WriteCompletions(os.Stdout, completions)
+			return
+		}
+
+		completer, ok := h.completers[args[0]]
+		if !ok {
+			return
+		}
+
+		completions, err := completer(args[:len(args)-1], args[len(args)-1])
+		if err != nil {
+			panic(err)
+		}
+		_ = goplug.WriteCompletions(os.Stdout, completions)
+		return
+	}
+
 	if os.Args[1] == "hello" {
-		fmt.Println("world")
+		// Let plugins modify the output.
+		output := "world"
+		err := g.ApplyFilters(context.Background(), "hello.output", &output)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Println(output)
 		return
 	}
 
@@ -65,6 +151,18 @@ func main() {
 	for key, cmd := range h.commands {
 		if key == os.Args[1] {
 			cmd(os.Args[1:])
+
+			// Notify all listeners.
+			results, err := g.Publish(context.Background(), "command.executed", key)
+			if err != nil {
+				panic(err)
+			}
+
+			for _, res := range results {
+				if res.Err != nil {
+					fmt.Println(res.PluginID, "failed to handle the event:", res.Err)
+				}
+			}
 			return
 		}
 	}
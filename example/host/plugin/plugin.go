@@ -1,22 +1,17 @@
 package plugin
 
 import (
-	"encoding/json"
+	"errors"
 	"os"
 
 	"github.com/aligator/goplug/example/host/actions"
+	"github.com/aligator/goplug/example/host/api"
 	"github.com/aligator/goplug/goplug"
 )
 
-type TestMetadata struct {
-	Command string `json:"command"`
-}
-
 type Plugin struct {
 	actions.ClientActions
-	client         *goplug.Client
-	subCommand     string
-	subCommandFunc func(args []string) error
+	client *goplug.Client
 }
 
 func New(info goplug.PluginInfo) Plugin {
@@ -29,32 +24,40 @@ func New(info goplug.PluginInfo) Plugin {
 	}
 }
 
-// SetSubCommand - for this example support only one subcommand per client.
+// AddSubCommand adds a subcommand to the plugin.
+// Each subcommand is an entry point of the plugin.
 // This is host implementation specific
-func (p *Plugin) SetSubCommand(name string, subCommand func(args []string) error) error {
-	meta := TestMetadata{
-		Command: name,
-	}
+func (p *Plugin) AddSubCommand(entry goplug.EntryPoint, subCommand func(args []string) error) error {
+	return p.AddEntryPoint(entry, api.TestMetadata{
+		Command: entry.Name,
+	}, subCommand)
+}
 
-	metaJson, err := json.Marshal(meta)
-	if err != nil {
-		return err
-	}
+// OnComplete sets the handler which completes the arguments of the
+// subcommands.
+func (p *Plugin) OnComplete(handler goplug.CompletionHandler) {
+	p.client.OnComplete(handler)
+}
 
-	p.subCommandFunc = subCommand
-	p.subCommand = name
-	p.client.Metadata = string(metaJson)
+// CallPlugin calls a service of another plugin.
+func (p *Plugin) CallPlugin(pluginID string, service string, args interface{}, reply interface{}) error {
+	return p.client.CallPlugin(pluginID, service, args, reply)
+}
 
-	return nil
+// Config unmarshals the config passed by the host into v.
+func (p *Plugin) Config(v interface{}) error {
+	return p.client.Config(v)
 }
 
 func (p *Plugin) Run() {
-	p.client.Init()
+	err := p.client.Init()
+	if err != nil {
+		panic(err)
+	}
 
-	if os.Args[1] == p.subCommand {
-		err := p.subCommandFunc(os.Args[1:])
-		if err != nil {
-			panic(err)
-		}
+	// Run the subcommand the host invoked.
+	err = p.client.Dispatch(os.Args[1:])
+	if err != nil && !errors.Is(err, goplug.ErrNoEntryPoint) {
+		panic(err)
 	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/aligator/goplug/example/host/plugin"
+	"github.com/aligator/goplug/goplug"
+)
+
+type InteractivePlugin struct {
+	plugin.Plugin
+}
+
+func New() InteractivePlugin {
+	return InteractivePlugin{
+		Plugin: plugin.New(goplug.PluginInfo{
+			ID:          "interactivePlugin",
+			PluginType:  goplug.OneShot,
+			Name:        "Interactive",
+			Description: "Asks for your name using the terminal of the host",
+			Tags:        []string{"example"},
+			// The plugin gets the terminal of the host.
+			Interactive: true,
+		}),
+	}
+}
+
+// isTerminal checks if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func main() {
+	p := New()
+	p.AddSubCommand(goplug.EntryPoint{
+		Name:  "ask",
+		Short: "Ask for your name",
+	}, func(args []string) error {
+		// Ctrl+C is received by the plugin as it runs in the foreground.
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			fmt.Println("\nBye!")
+			os.Exit(130)
+		}()
+
+		fmt.Print("What is your name? ")
+		name, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		greeting := "Hello " + strings.TrimSpace(name) + "!"
+		if isTerminal(os.Stdout) {
+			// Only use colors if stdout is a terminal.
+			greeting = "\033[32m" + greeting + "\033[0m"
+		}
+		fmt.Println(greeting)
+
+		// The rpc still works as usual.
+		p.PrintHello()
+		return nil
+	})
+
+	p.Run()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aligator/goplug/goplug"
+)
+
+func main() {
+	c := goplug.Client{
+		PluginInfo: goplug.PluginInfo{
+			ID:               "listenerPlugin",
+			PluginType:       goplug.Listener,
+			Name:             "Listener",
+			Description:      "Counts the executed commands and shouts the output",
+			Version:          "1.0.0",
+			MinGoPlugVersion: "0.1.0",
+		},
+	}
+
+	// Count the executed commands and persist the count when the
+	// plugin gets stopped.
+	var executed int64
+	c.OnStart(func() error {
+		value, err := c.StorageGet("executed")
+		if errors.Is(err, goplug.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		executed, err = strconv.ParseInt(string(value), 10, 64)
+		return err
+	})
+
+	c.OnStop(func(reason goplug.Reason) error {
+		count := atomic.LoadInt64(&executed)
+		return c.StorageSet("executed", []byte(strconv.FormatInt(count, 10)))
+	})
+
+	c.OnEvent("command.executed", func(event goplug.Event) error {
+		var command string
+		err := event.Decode(&command)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&executed, 1)
+
+		return c.Print(fmt.Sprintf("Listener: the command %v was executed\n", command))
+	})
+
+	c.OnFilter("hello.output", goplug.DefaultFilterPriority, func(value goplug.FilterValue) (interface{}, error) {
+		var text string
+		err := value.Decode(&text)
+		if err != nil {
+			return nil, err
+		}
+
+		return strings.ToUpper(text) + "!", nil
+	})
+
+	c.Handle("Shout", func(call goplug.ServiceCall) (interface{}, error) {
+		var text string
+		err := call.Decode(&text)
+		if err != nil {
+			return nil, err
+		}
+
+		return strings.ToUpper(text) + "!", nil
+	})
+
+	err := c.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	err = c.Listen()
+	if err != nil {
+		panic(err)
+	}
+}
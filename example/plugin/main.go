@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/aligator/goplug/example/host/plugin"
 	"github.com/aligator/goplug/goplug"
+	"github.com/spf13/pflag"
 )
 
+// Config is the config which can be set for the plugin by the host.
+type Config struct {
+	Greeting string `json:"greeting"`
+}
+
 type SuperPlugin struct {
 	plugin.Plugin
 }
@@ -16,15 +25,42 @@ type SuperPlugin struct {
 func New() SuperPlugin {
 	return SuperPlugin{
 		Plugin: plugin.New(goplug.PluginInfo{
-			ID:         "superplugin",
-			PluginType: goplug.OneShot,
+			ID:          "superplugin",
+			PluginType:  goplug.OneShot,
+			Name:        "Super Plugin",
+			Description: "Shows what plugins can do with the host API",
+			Authors:     []string{"aligator"},
+			Homepage:    "https://github.com/aligator/goplug",
+			Tags:        []string{"example", "random"},
+			Version:     "1.0.0",
+			HostAPI:     ">=1.0.0 <2.0.0",
+			ConfigSchema: json.RawMessage(`{
+				"type": "object",
+				"properties": {
+					"greeting": {"type": "string", "minLength": 1}
+				}
+			}`),
 		}),
 	}
 }
 
+// zeroReader returns an endless amount of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
 func main() {
 	p := New()
-	p.SetSubCommand("rand", func(args []string) error {
+	p.AddSubCommand(goplug.EntryPoint{
+		Name:  "rand",
+		Short: "Print a random number",
+		Long:  "Print a random number in [0,n) and show what the host API can do.",
+	}, func(args []string) error {
 		if len(args) < 2 {
 			return errors.New("rand: invalid arg count")
 		}
@@ -41,8 +77,98 @@ func main() {
 
 		p.Print(fmt.Sprintf("Random result for input %v: \n%v\n", args[1], strconv.Itoa(rand)))
 
+		// Transfer some bigger data as stream.
+		count, err := p.CountBytes(io.LimitReader(zeroReader{}, 100*1024*1024))
+		if err != nil {
+			return err
+		}
+		p.Print(fmt.Sprintf("The host counted %v bytes\n", count))
+
+		reversed, err := p.Reverse([]byte(args[1]))
+		if err != nil {
+			return err
+		}
+		p.Print(fmt.Sprintf("Reversed input: %v\n", string(reversed)))
+
+		// Remember the input for the next run.
+		last, err := p.StorageGet("last-input")
+		if errors.Is(err, goplug.ErrKeyNotFound) {
+			p.Print("This is the first run\n")
+		} else if err != nil {
+			return err
+		} else {
+			p.Print(fmt.Sprintf("The input of the last run was %v\n", string(last)))
+		}
+
+		err = p.StorageSet("last-input", []byte(args[1]))
+		if err != nil {
+			return err
+		}
+
+		greeting := bytes.Buffer{}
+		err = p.WriteHello("superplugin", &greeting)
+		if err != nil {
+			return err
+		}
+		p.Print(greeting.String())
+
+		config := Config{
+			Greeting: "No greeting configured",
+		}
+		err = p.Config(&config)
+		if err != nil {
+			return err
+		}
+		p.Print(config.Greeting + "\n")
+
+		return nil
+	})
+
+	// A plugin may provide several subcommands.
+	p.AddSubCommand(goplug.EntryPoint{
+		Name:    "reverse",
+		Short:   "Reverse the input",
+		Aliases: []string{"rev"},
+		Flags: []goplug.Flag{
+			{Name: "upper", Shorthand: "u", Type: goplug.FlagBool, Usage: "print the result in upper case"},
+		},
+	}, func(args []string) error {
+		// The plugin parses its flags by itself.
+		flags := pflag.NewFlagSet("reverse", pflag.ContinueOnError)
+		upper := flags.BoolP("upper", "u", false, "")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+
+		if flags.NArg() < 1 {
+			return errors.New("reverse: invalid arg count")
+		}
+
+		reversed, err := p.Reverse([]byte(flags.Arg(0)))
+		if err != nil {
+			return err
+		}
+
+		if *upper {
+			reversed = bytes.ToUpper(reversed)
+		}
+
+		p.Print(string(reversed) + "\n")
 		return nil
 	})
 
+	// Suggest some inputs for rand.
+	p.OnComplete(func(request goplug.CompletionRequest) ([]goplug.Completion, error) {
+		if request.EntryPoint != "rand" || len(request.Args) > 1 {
+			return nil, nil
+		}
+
+		return []goplug.Completion{
+			{Value: "10", Description: "a small random number"},
+			{Value: "1000", Description: "a big random number"},
+		}, nil
+	})
+
 	p.Run()
 }
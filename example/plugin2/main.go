@@ -12,15 +12,25 @@ type SuperPlugin struct {
 func New() SuperPlugin {
 	return SuperPlugin{
 		Plugin: plugin.New(goplug.PluginInfo{
-			ID:         "servusPlugin",
-			PluginType: goplug.OneShot,
+			ID:          "servusPlugin",
+			PluginType:  goplug.OneShot,
+			Name:        "Servus",
+			Description: "Greets in bavarian",
+			Tags:        []string{"example"},
+			// The Shout service of the listener is used.
+			Dependencies: []goplug.Dependency{
+				{ID: "listenerPlugin", Version: "^1.0.0"},
+			},
 		}),
 	}
 }
 
 func main() {
 	p := New()
-	p.SetSubCommand("servus", func(args []string) error {
+	p.AddSubCommand(goplug.EntryPoint{
+		Name:  "servus",
+		Short: "Say servus",
+	}, func(args []string) error {
 		p.PrintHello()
 		p.Print("I bins, da Aligator!I bins, da Aligator!I bins, da Aligator!I bins, da Aligator!I bins, da Aligator!I bins, da Aligator!\n")
 		p.Print("I bins, da Aligator!\n")
@@ -79,6 +89,14 @@ func main() {
 		p.PrintHello()
 		p.Print("Call again to test if the app ref works:\n")
 		p.PrintHello()
+
+		// Call a service of another plugin.
+		var shout string
+		err := p.CallPlugin("listenerPlugin", "Shout", "servus", &shout)
+		if err != nil {
+			return err
+		}
+		p.Print(shout + "\n")
 		return nil
 	})
 
@@ -2,8 +2,11 @@ package generate
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/aligator/checkpoint"
 	"github.com/spf13/afero"
 	"go/ast"
@@ -13,6 +16,7 @@ import (
 	"golang.org/x/tools/go/packages"
 	"io/fs"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -21,6 +25,7 @@ import (
 
 var (
 	ErrTypeNotSupported = errors.New("type not supported (some types can be activated by GoPlug options)")
+	ErrMultipleMetadata = errors.New("only one struct may be annotated with //goplug:metadata")
 )
 
 type match struct {
@@ -33,6 +38,16 @@ type match struct {
 	imports  []Import
 }
 
+// metadataMatch is the struct annotated with '//goplug:metadata'.
+type metadataMatch struct {
+	name string
+	pack *ast.Package
+	path string
+}
+
+// DefaultAPIVersion is used if no APIVersion is set.
+const DefaultAPIVersion = "1.0.0"
+
 type atomicInt32 struct {
 	internal *int32
 }
@@ -81,7 +96,13 @@ type Generator struct {
 	// Be aware that these slices are always copied.
 	AllowSlices bool
 
+	// APIVersion is the semver of the host API which is stamped into the
+	// generated actions. It should be increased whenever the actions change.
+	// If it is empty, DefaultAPIVersion is used.
+	APIVersion string
+
 	found        []match
+	metadata     *metadataMatch
 	generated    *PluginData
 	finalImports map[string]Import
 
@@ -144,6 +165,15 @@ func (g *Generator) Search() error {
 		for _, p := range pkgs {
 			for _, f := range p.Files {
 				for _, d := range f.Decls {
+					// Structs may be annotated as metadata.
+					if genDecl, ok := d.(*ast.GenDecl); ok {
+						err := g.searchMetadata(genDecl, p, packagePath)
+						if err != nil {
+							return err
+						}
+						continue
+					}
+
 					// Only functions are interesting.
 					funcDecl, ok := d.(*ast.FuncDecl)
 					if !ok || funcDecl.Doc == nil {
@@ -245,18 +275,79 @@ func (g *Generator) Search() error {
 	return nil
 }
 
+// hasAnnotation checks if the comment group contains the annotation.
+func hasAnnotation(doc *ast.CommentGroup, annotation string) bool {
+	if doc == nil {
+		return false
+	}
+
+	for _, c := range doc.List {
+		if strings.HasPrefix(c.Text, annotation) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchMetadata checks if the declaration contains a struct annotated with
+// '//goplug:metadata'. Only one such struct is allowed.
+func (g *Generator) searchMetadata(genDecl *ast.GenDecl, p *ast.Package, packagePath string) error {
+	if genDecl.Tok != token.TYPE {
+		return nil
+	}
+
+	for _, spec := range genDecl.Specs {
+		typeSpec := spec.(*ast.TypeSpec)
+		if _, ok := typeSpec.Type.(*ast.StructType); !ok {
+			continue
+		}
+
+		// For single type declarations the comment is attached to the
+		// declaration instead of the spec.
+		if !hasAnnotation(typeSpec.Doc, "//goplug:metadata") &&
+			!(len(genDecl.Specs) == 1 && hasAnnotation(genDecl.Doc, "//goplug:metadata")) {
+			continue
+		}
+
+		if g.metadata != nil {
+			return checkpoint.From(fmt.Errorf("%v and %v: %w", g.metadata.name, typeSpec.Name.Name, ErrMultipleMetadata))
+		}
+
+		g.metadata = &metadataMatch{
+			name: typeSpec.Name.Name,
+			pack: p,
+			path: packagePath,
+		}
+	}
+
+	return nil
+}
+
+// StreamKind defines how a param is transferred over the side channel
+// instead of the jsonrpc codec.
+type StreamKind string
+
+const (
+	NoStream     = StreamKind("")
+	ReaderStream = StreamKind("reader")
+	WriterStream = StreamKind("writer")
+	BytesStream  = StreamKind("bytes")
+)
+
 type Param struct {
 	Name       string
 	NamePublic string
 	Type       string
+	Stream     StreamKind
 }
 
 type Action struct {
-	Name     string
-	Comment  string
-	Ref      string
-	Request  []Param
-	Response []Param
+	Name       string
+	Comment    string
+	Ref        string
+	Request    []Param
+	Response   []Param
+	HasStreams bool
 }
 
 type Reference struct {
@@ -275,6 +366,16 @@ type PluginData struct {
 	Imports    []Import
 	References []Reference
 	Actions    []Action
+
+	// Metadata is the type of the struct annotated with '//goplug:metadata'.
+	// It is empty if there is none.
+	Metadata string
+
+	// APIVersion is the semver of the host API.
+	APIVersion string
+
+	// ActionsHash is a hash of the signatures of all actions.
+	ActionsHash string
 }
 
 func (g *Generator) mapParamType(expr ast.Expr, actionMatch match, packageName string) (string, error) {
@@ -340,6 +441,60 @@ func (g *Generator) mapParamType(expr ast.Expr, actionMatch match, packageName s
 	return mapper(expr, actionMatch, packageName, false)
 }
 
+// mapStreamType checks if the type is transferred over the side channel.
+// These are io.Reader, io.Writer and []byte. They are always allowed,
+// independent of the options.
+// If it is no stream type, NoStream is returned.
+func (g *Generator) mapStreamType(expr ast.Expr, actionMatch match) (StreamKind, string, error) {
+	switch v := expr.(type) {
+	case *ast.ArrayType:
+		elt, ok := v.Elt.(*ast.Ident)
+		if ok && v.Len == nil && elt.Name == "byte" && elt.Obj == nil {
+			return BytesStream, "[]byte", nil
+		}
+	case *ast.SelectorExpr:
+		ident, ok := v.X.(*ast.Ident)
+		if !ok {
+			return NoStream, "", nil
+		}
+
+		var kind StreamKind
+		switch v.Sel.Name {
+		case "Reader":
+			kind = ReaderStream
+		case "Writer":
+			kind = WriterStream
+		default:
+			return NoStream, "", nil
+		}
+
+		// Check if it really references the io package.
+		isIO := false
+		for _, imp := range actionMatch.imports {
+			if imp.Path != "io" {
+				continue
+			}
+
+			if imp.FakeName == ident.Name || (imp.FakeName == "" && imp.Name == ident.Name) {
+				isIO = true
+				break
+			}
+		}
+		if !isIO {
+			return NoStream, "", nil
+		}
+
+		fakeName, err := g.addImport("io", nil)
+		if err != nil {
+			return NoStream, "", checkpoint.From(err)
+		}
+
+		return kind, fakeName + "." + v.Sel.Name, nil
+	}
+
+	return NoStream, "", nil
+}
+
 // addImport adds the given name or path to the imports.
 // If fileImports is given it is used to resolve it.
 // If nameOrPath is a name the fileImports are mandatory, to resolve it.
@@ -453,15 +608,25 @@ func (g *Generator) Generate() error {
 
 		// Add parameters.
 		for _, param := range action.fn.Type.Params.List {
-			paramType, err := g.mapParamType(param.Type, action, fakeName)
+			stream, paramType, err := g.mapStreamType(param.Type, action)
 			if err != nil {
 				return err
 			}
 
+			if stream == NoStream {
+				paramType, err = g.mapParamType(param.Type, action, fakeName)
+				if err != nil {
+					return err
+				}
+			} else {
+				actionData.HasStreams = true
+			}
+
 			actionData.Request = append(actionData.Request, Param{
 				Name:       param.Names[0].Name,
 				NamePublic: strings.ToUpper(string(param.Names[0].Name[0])) + param.Names[0].Name[1:],
 				Type:       paramType,
+				Stream:     stream,
 			})
 
 		}
@@ -475,11 +640,22 @@ func (g *Generator) Generate() error {
 				break
 			}
 
-			resType, err := g.mapParamType(res.Type, action, fakeName)
+			stream, resType, err := g.mapStreamType(res.Type, action)
 			if err != nil {
 				return err
 			}
 
+			if stream == WriterStream {
+				return checkpoint.Wrap(errors.New("io.Writer is not allowed as result"), ErrTypeNotSupported)
+			} else if stream == NoStream {
+				resType, err = g.mapParamType(res.Type, action, fakeName)
+				if err != nil {
+					return err
+				}
+			} else {
+				actionData.HasStreams = true
+			}
+
 			name := ""
 			if len(res.Names) >= 1 {
 				name = res.Names[0].Name
@@ -491,19 +667,71 @@ func (g *Generator) Generate() error {
 				Name:       name,
 				NamePublic: strings.ToUpper(string(name[0])) + name[1:],
 				Type:       resType,
+				Stream:     stream,
 			})
 		}
 
 		g.generated.Actions = append(g.generated.Actions, actionData)
 	}
 
+	// Add the metadata type.
+	if g.metadata != nil {
+		importPath := filepath.ToSlash(filepath.Join(g.Module, g.metadata.path))
+		fakeName, err := g.addImport(importPath, []Import{
+			{
+				FakeName: "",
+				Name:     g.metadata.pack.Name,
+				Path:     importPath,
+			},
+		})
+		if err != nil {
+			return checkpoint.From(err)
+		}
+
+		g.generated.Metadata = fakeName + "." + g.metadata.name
+	}
+
 	for _, imp := range g.finalImports {
 		g.generated.Imports = append(g.generated.Imports, imp)
 	}
 
+	g.generated.APIVersion = g.APIVersion
+	if g.generated.APIVersion == "" {
+		g.generated.APIVersion = DefaultAPIVersion
+	}
+	g.generated.ActionsHash = g.actionsHash()
+
 	return nil
 }
 
+// actionsHash calculates a hash of the signatures of all actions.
+// The generated import names are replaced by the import paths, so that
+// the hash does not depend on the order of the imports.
+func (g *Generator) actionsHash() string {
+	replacements := make([]string, 0)
+	for _, imp := range g.finalImports {
+		replacements = append(replacements, imp.FakeName+".", imp.Path+".")
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	types := func(params []Param) string {
+		res := make([]string, len(params))
+		for i, param := range params {
+			res[i] = replacer.Replace(param.Type)
+		}
+		return strings.Join(res, ",")
+	}
+
+	signatures := make([]string, len(g.generated.Actions))
+	for i, action := range g.generated.Actions {
+		signatures[i] = action.Name + "(" + types(action.Request) + ")(" + types(action.Response) + ")"
+	}
+	sort.Strings(signatures)
+
+	sum := sha256.Sum256([]byte(strings.Join(signatures, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
 //go:embed template
 var templateFS embed.FS
 
@@ -4,7 +4,11 @@ go 1.16
 
 require (
 	github.com/aligator/checkpoint v0.0.2
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
 	github.com/spf13/afero v1.6.0
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/cobra v1.5.0
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/mod v0.4.2
 	golang.org/x/tools v0.1.3
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -4,7 +4,12 @@
 package goplug
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/aligator/checkpoint"
 )
 
 type PrintHelloRequest struct {
@@ -15,7 +20,23 @@ type PrintHelloResponse struct{}
 
 // HostControl provides some basic commands available to all plugins.
 type HostControl struct {
-	GoPlug GoPlug
+	GoPlug *GoPlug
+
+	// plugin is the plugin which is connected to this HostControl.
+	plugin *plugin
+
+	// done gets closed when the plugin process exited.
+	done <-chan struct{}
+
+	// streams is the side channel of the plugin.
+	streams *streamMux
+
+	// registry contains all streams of the plugin which were not claimed
+	// by any host action yet.
+	registry *StreamRegistry
+
+	// control contains the control messages for the plugin process.
+	control *controlQueue
 }
 
 // Print is the host implementation of a simple Print command.
@@ -36,3 +57,174 @@ func (c *Client) Print(text string) error {
 	}, &response)
 	return err
 }
+
+// StreamDirection defines in which direction a stream sends its data.
+type StreamDirection string
+
+const (
+	// ToHost streams send data from the plugin to the host.
+	ToHost = StreamDirection("to_host")
+
+	// ToPlugin streams send data from the host to the plugin.
+	ToPlugin = StreamDirection("to_plugin")
+)
+
+type OpenStreamRequest struct {
+	Direction StreamDirection
+}
+
+type OpenStreamResponse struct {
+	ID StreamID
+}
+
+// OpenStream opens a new stream on the side channel of the plugin.
+// Until a host action claims it, it is kept in the stream registry of the
+// plugin.
+func (h *HostControl) OpenStream(args OpenStreamRequest, reply *OpenStreamResponse) error {
+	id := nextStreamID()
+
+	switch args.Direction {
+	case ToHost:
+		h.registry.add(id, h.streams.receive(id))
+	case ToPlugin:
+		h.registry.add(id, h.streams.writer(id))
+	default:
+		return checkpoint.From(fmt.Errorf("invalid stream direction %v", args.Direction))
+	}
+
+	*reply = OpenStreamResponse{
+		ID: id,
+	}
+	return nil
+}
+
+type PullStreamRequest struct {
+	ID StreamID
+}
+
+type PullStreamResponse struct{}
+
+// PullStream starts sending a stream served by the host to the plugin.
+// Only streams served for the plugin itself can be pulled.
+func (h *HostControl) PullStream(args PullStreamRequest, reply *PullStreamResponse) error {
+	entry, ok := h.registry.claim(args.ID)
+	if !ok {
+		return checkpoint.From(fmt.Errorf("StreamID: %v: %w", args.ID, ErrStreamDoesNotExist))
+	}
+
+	served, ok := entry.(servedStream)
+	if !ok {
+		closeStreamEntry(entry)
+		return checkpoint.From(fmt.Errorf("StreamID: %v: not served: %w", args.ID, ErrStreamDoesNotExist))
+	}
+
+	go func() {
+		_ = h.streams.send(args.ID, served.r)
+		if closer, ok := served.r.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}()
+
+	return nil
+}
+
+// closeStreams closes all streams of the plugin which were not claimed
+// by any host action, including the streams served by the host which were
+// never pulled.
+func (h *HostControl) closeStreams() {
+	h.registry.close()
+}
+
+// SendStream starts sending the data of r to the host.
+// The ID of the returned Transfer has to be passed to the host action.
+// After the action returned, Wait has to be called.
+func (c *Client) SendStream(r io.Reader) (*Transfer, error) {
+	response := OpenStreamResponse{}
+	err := c.client.Call("HostControl.OpenStream", OpenStreamRequest{
+		Direction: ToHost,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &abortableReader{r: r}
+	t := &Transfer{
+		ID:   response.ID,
+		done: make(chan struct{}),
+		abort: func() {
+			atomic.StoreInt32(&reader.aborted, 1)
+		},
+	}
+
+	go func() {
+		defer close(t.done)
+		t.err = c.streams.send(t.ID, reader)
+	}()
+
+	return t, nil
+}
+
+// SendBytes starts sending the data to the host.
+// It works the same as SendStream.
+func (c *Client) SendBytes(data []byte) (*Transfer, error) {
+	return c.SendStream(bytes.NewReader(data))
+}
+
+// ReceiveStream opens a stream which the host can write to.
+// Everything it receives is copied to w.
+// The ID of the returned Transfer has to be passed to the host action.
+// After the action returned, Wait has to be called.
+func (c *Client) ReceiveStream(w io.Writer) (*Transfer, error) {
+	response := OpenStreamResponse{}
+	err := c.client.Call("HostControl.OpenStream", OpenStreamRequest{
+		Direction: ToPlugin,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	r := c.streams.receive(response.ID)
+	t := &Transfer{
+		ID:   response.ID,
+		done: make(chan struct{}),
+		abort: func() {
+			_ = r.Close()
+		},
+	}
+
+	go func() {
+		defer close(t.done)
+		defer r.Close()
+		_, err := io.Copy(w, r)
+		t.err = checkpoint.From(err)
+	}()
+
+	return t, nil
+}
+
+// PullStream receives a stream which was served by the host.
+// The returned reader has to be closed after usage.
+func (c *Client) PullStream(id StreamID) (io.ReadCloser, error) {
+	r := c.streams.receive(id)
+	err := c.client.Call("HostControl.PullStream", PullStreamRequest{
+		ID: id,
+	}, &PullStreamResponse{})
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// PullBytes receives a whole stream which was served by the host.
+func (c *Client) PullBytes(id StreamID) ([]byte, error) {
+	r, err := c.PullStream(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	return data, checkpoint.From(err)
+}
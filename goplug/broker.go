@@ -0,0 +1,216 @@
+// File broker.go contains the broker which routes calls from one plugin to
+// services of another plugin through the host.
+
+package goplug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrServiceNotExposed = errors.New("service is not exposed by the plugin")
+	ErrCallCycle         = errors.New("call cycle detected")
+	ErrCallNotAllowed    = errors.New("call is not allowed")
+	ErrCallTimeout       = errors.New("call timed out")
+)
+
+// DefaultCallTimeout is used if GoPlug.CallTimeout is not set.
+const DefaultCallTimeout = 30 * time.Second
+
+// CallPolicy decides if the caller plugin is allowed to call the service of
+// the target plugin. It returns an error if the call is not allowed.
+type CallPolicy func(caller PluginInfo, target PluginInfo, service string) error
+
+type CallPluginRequest struct {
+	Target  string
+	Service string
+	Args    json.RawMessage
+
+	// Chain contains the IDs of all plugins which are already waiting for
+	// this call. It is used to detect cycles.
+	Chain []string
+}
+
+type CallPluginResponse struct {
+	Reply json.RawMessage
+}
+
+// CallPlugin routes a call of a plugin to the service of another plugin.
+// The target plugin is started if it is not running yet.
+// Only services listed in the PluginInfo.Services of the target can be
+// called. Additionally the GoPlug.CallPolicy is checked if it is set.
+// If the target does not reply within the GoPlug.CallTimeout, the call
+// fails with ErrCallTimeout.
+func (h *HostControl) CallPlugin(args CallPluginRequest, reply *CallPluginResponse) error {
+	if h.plugin == nil || h.GoPlug == nil {
+		return checkpoint.From(ErrCallNotAllowed)
+	}
+
+	chain := append(args.Chain, h.plugin.ID)
+	for _, ID := range chain {
+		if ID == args.Target {
+			return checkpoint.From(fmt.Errorf("%v -> %v: %w", strings.Join(chain, " -> "), args.Target, ErrCallCycle))
+		}
+	}
+
+	h.GoPlug.listenerPluginsMutex.Lock()
+	target, ok := h.GoPlug.listenerPlugins[args.Target]
+	h.GoPlug.listenerPluginsMutex.Unlock()
+	if !ok {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %w", args.Target, ErrPluginDoesNotExist))
+	}
+
+	exposed := false
+	for _, service := range target.Services {
+		if service == args.Service {
+			exposed = true
+			break
+		}
+	}
+	if !exposed {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: service %v: %w", args.Target, args.Service, ErrServiceNotExposed))
+	}
+
+	if h.GoPlug.CallPolicy != nil {
+		err := h.GoPlug.CallPolicy(h.plugin.PluginInfo, target.PluginInfo, args.Service)
+		if err != nil {
+			return checkpoint.Wrap(fmt.Errorf("%v -> %v.%v: %w", h.plugin.ID, args.Target, args.Service, err), ErrCallNotAllowed)
+		}
+	}
+
+	d := newDelivery(CallMessage, args.Service, args.Args)
+	d.Caller = h.plugin.ID
+	d.Chain = chain
+	h.GoPlug.deliver(target, d)
+
+	timeout := h.GoPlug.CallTimeout
+	if timeout <= 0 {
+		timeout = DefaultCallTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var res ackResult
+	select {
+	case res = <-d.result:
+	case <-timer.C:
+		return checkpoint.Wrap(fmt.Errorf("%v -> %v.%v: no reply after %v", h.plugin.ID, args.Target, args.Service, timeout), ErrCallTimeout)
+	case <-h.done:
+		// Nobody waits for the reply anymore.
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %w", h.plugin.ID, ErrPluginExited))
+	}
+
+	if res.Err != nil {
+		return res.Err
+	}
+
+	*reply = CallPluginResponse{
+		Reply: res.Payload,
+	}
+	return nil
+}
+
+// ServiceCall is a call of a service from another plugin.
+type ServiceCall struct {
+	// Caller is the ID of the calling plugin.
+	Caller  string
+	Service string
+	Args    json.RawMessage
+
+	client *Client
+
+	// chain contains the IDs of all plugins waiting for this call.
+	chain []string
+}
+
+// Decode unmarshals the arguments into v.
+func (s ServiceCall) Decode(v interface{}) error {
+	return checkpoint.From(json.Unmarshal(s.Args, v))
+}
+
+// CallPlugin calls a service of another plugin while handling this call.
+// It has to be used instead of Client.CallPlugin inside of a ServiceHandler,
+// so that call cycles can be detected. The host only knows the chain of
+// calls passed along this way.
+func (s ServiceCall) CallPlugin(pluginID string, service string, args interface{}, reply interface{}) error {
+	return s.client.callPlugin(s.chain, pluginID, service, args, reply)
+}
+
+// ServiceHandler handles calls of a service.
+// The returned value is sent back to the caller as json.
+type ServiceHandler func(call ServiceCall) (interface{}, error)
+
+// Handle exposes a service which other plugins can call using CallPlugin.
+// It has to be called before Init.
+func (c *Client) Handle(service string, handler ServiceHandler) {
+	if c.serviceHandlers == nil {
+		c.serviceHandlers = make(map[string]ServiceHandler)
+	}
+
+	if _, ok := c.serviceHandlers[service]; !ok {
+		c.Services = append(c.Services, service)
+	}
+	c.serviceHandlers[service] = handler
+}
+
+// CallPlugin calls a service of another plugin.
+// The args are sent as json and the result is unmarshalled into reply.
+//
+// Inside of a ServiceHandler, ServiceCall.CallPlugin has to be used
+// instead. Otherwise the call starts a new chain and a cycle back to a
+// waiting plugin is not detected. Such a call fails only after the
+// GoPlug.CallTimeout of the host.
+func (c *Client) CallPlugin(pluginID string, service string, args interface{}, reply interface{}) error {
+	return c.callPlugin(nil, pluginID, service, args, reply)
+}
+
+func (c *Client) callPlugin(chain []string, pluginID string, service string, args interface{}, reply interface{}) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	response := CallPluginResponse{}
+	err = c.client.Call("HostControl.CallPlugin", CallPluginRequest{
+		Target:  pluginID,
+		Service: service,
+		Args:    data,
+		Chain:   chain,
+	}, &response)
+	if err != nil {
+		return err
+	}
+
+	if reply == nil {
+		return nil
+	}
+	return checkpoint.From(json.Unmarshal(response.Reply, reply))
+}
+
+// callService calls the service handler registered for the message.
+func (c *Client) callService(m Message) (json.RawMessage, error) {
+	handler, ok := c.serviceHandlers[m.Topic]
+	if !ok {
+		return nil, fmt.Errorf("service %v: %w", m.Topic, ErrNoHandler)
+	}
+
+	res, err := handler(ServiceCall{
+		Caller:  m.Caller,
+		Service: m.Topic,
+		Args:    m.Payload,
+		client:  c,
+		chain:   m.Chain,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(res)
+	return data, checkpoint.From(err)
+}
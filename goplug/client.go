@@ -2,47 +2,156 @@ package goplug
 
 import (
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"net/rpc"
 	"net/rpc/jsonrpc"
 	"os"
+	"sync"
 
+	"github.com/aligator/checkpoint"
 	"github.com/aligator/goplug/common"
 )
 
+var (
+	ErrNoHost = errors.New("the plugin was not started by a goplug host")
+)
+
+// modeEnv is the environment variable which tells the plugin why the host
+// started it.
+const modeEnv = "GOPLUG_MODE"
+
+const (
+	// discoverMode is used to get the PluginInfo.
+	discoverMode = "discover"
+
+	// connectMode is used to connect the plugin to the host.
+	connectMode = "connect"
+
+	// completeMode is used to get the shell completions of the plugin.
+	completeMode = "complete"
+)
+
 // Client is the basis of all Plugins.
 // To use it the Init method has to be called.
 // Which starts the client.
 type Client struct {
 	PluginInfo
 	client *rpc.Client
+
+	// streams is the side channel used to transfer binary data.
+	streams *streamMux
+
+	// eventHandlers contains the handlers registered by OnEvent.
+	eventHandlers map[string]EventHandler
+
+	// filterHandlers contains the handlers registered by OnFilter.
+	filterHandlers map[string]FilterHandler
+
+	// serviceHandlers contains the handlers registered by Handle.
+	serviceHandlers map[string]ServiceHandler
+
+	// entryPointHandlers contains the handlers registered by AddEntryPoint.
+	entryPointHandlers map[string]EntryPointHandler
+
+	// entryPoint is the name of the entry point invoked by the host.
+	entryPoint string
+
+	// config is the config passed by the host in completion mode.
+	config json.RawMessage
+
+	// completionHandler is the handler registered by OnComplete.
+	completionHandler CompletionHandler
+
+	// The lifecycle handlers registered by OnStart, OnStop and
+	// OnHostShutdown.
+	startHandlers        []func() error
+	stopHandlers         []StopHandler
+	hostShutdownHandlers []func() error
+
+	// stopped gets closed when the host stopped the plugin.
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// initOnce makes sure Init only runs once.
+	initOnce sync.Once
+	initErr  error
 }
 
 // Init starts the client and connects to jsonrpc.
-// If the flag "-init" is passed, it only returns its
-// plugin information to stdout as json and exits.
+// If the host started the plugin for the discovery, it only returns its
+// plugin information to stdout as json and exits. If the host started it
+// for the completion, it returns the completions of the CompletionHandler
+// in the same way.
+//
+// The mode is passed by the host through an environment variable, so the
+// arguments of the process are left untouched for the plugin itself.
+// If the plugin was not started by a host, ErrNoHost is returned.
+// Calling Init more than once has no effect.
 func (c *Client) Init() error {
-	init := flag.Bool("init", false, "")
-	flag.Parse()
+	c.initOnce.Do(func() {
+		c.initErr = c.init()
+	})
+	return c.initErr
+}
 
-	// Return the plugin info on init just using stdout.
-	if *init {
+func (c *Client) init() error {
+	mode := os.Getenv(modeEnv)
+
+	// Do not pass the mode to processes started by the plugin.
+	_ = os.Unsetenv(modeEnv)
+	c.readEntryPoint()
+	c.readConfig()
+
+	switch mode {
+	case discoverMode:
+		// Return the plugin info on init just using stdout.
 		res, err := json.Marshal(c.PluginInfo)
 		if err != nil {
 			panic(err)
 		}
 		fmt.Print(string(res))
 		os.Exit(0)
+	case completeMode:
+		// Return the completions just using stdout.
+		err := c.complete()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case connectMode:
+	default:
+		return checkpoint.From(ErrNoHost)
 	}
 
-	// If it is a one shot plugin, it needs to be able to communicate
-	// with the host using rpc to query data.
-	if c.PluginType == OneShot {
-		c.client = jsonrpc.NewClient(common.CombinedReadWriter{
-			In:  os.Stdin,
-			Out: os.Stdout,
-		})
+	// If it is a one shot or listener plugin, it needs to be able to
+	// communicate with the host using rpc to query data.
+	if c.PluginType == OneShot || c.PluginType == Listener {
+		// Interactive plugins use their stdin and stdout for the terminal.
+		if isInteractiveClient() {
+			c.client = jsonrpc.NewClient(newRPCFromFds())
+		} else {
+			c.client = jsonrpc.NewClient(common.CombinedReadWriter{
+				In:  os.Stdin,
+				Out: os.Stdout,
+			})
+		}
+
+		c.streams = newStreamMuxFromFds()
+		go func() {
+			_ = c.streams.serve()
+		}()
+
+		// The first control message starts the plugin.
+		c.stopped = make(chan struct{})
+		ok, err := c.receiveControl()
+		if err != nil {
+			return err
+		}
+		if ok {
+			go c.serveControl()
+		}
 	}
 
 	return nil
@@ -0,0 +1,295 @@
+// Package cobrahost implements a goplug.Host which adds the OneShot plugins
+// as subcommands to a cobra.Command.
+//
+// Each entry point of a plugin becomes a subcommand which uses the name,
+// help texts, aliases and flags of the entry point. Plugins without entry
+// points become a subcommand named by the last path element of their ID.
+//
+// Example:
+//
+//	root := &cobra.Command{Use: "host"}
+//	h := cobrahost.New(root)
+//	g := goplug.GoPlug{
+//		PluginFolder: "./plugin-bin",
+//		Host:         h,
+//		Actions:      &actions.HostActions{},
+//	}
+//	h.GoPlug = &g
+//	_, err := g.Init()
+//	...
+//	os.Exit(cobrahost.ExitCode(root.Execute()))
+package cobrahost
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/aligator/checkpoint"
+	"github.com/aligator/goplug/goplug"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	ErrCommandExists      = errors.New("command already exists")
+	ErrUnsupportedFlag    = errors.New("flag type is not supported")
+	ErrInvalidFlagDefault = errors.New("invalid default value of the flag")
+)
+
+// Host adds a subcommand to the Root for each OneShot plugin or each entry
+// point of a plugin.
+// It implements goplug.Host, goplug.EntryPointHost and goplug.ReloadHost.
+type Host struct {
+	// Root is the command to which the subcommands are added.
+	Root *cobra.Command
+
+	// GoPlug is used for the completion of the arguments of the
+	// subcommands. If it is nil, only the flags are completed.
+	GoPlug *goplug.GoPlug
+
+	// commands contains the subcommands of each plugin keyed by its ID.
+	commands map[string][]*cobra.Command
+	mutex    sync.Mutex
+}
+
+// New creates a Host which adds the subcommands to the root command.
+func New(root *cobra.Command) *Host {
+	return &Host{
+		Root:     root,
+		commands: make(map[string][]*cobra.Command),
+	}
+}
+
+// RegisterOneShot adds a subcommand named by the last path element of the
+// plugin ID, see CommandName.
+// The description of the plugin is used as help text.
+func (h *Host) RegisterOneShot(info goplug.PluginInfo, action goplug.OnOneShot) error {
+	return h.RegisterEntryPoint(info, goplug.EntryPoint{
+		Name:  CommandName(info.ID),
+		Short: info.Description,
+	}, action)
+}
+
+// RegisterEntryPoint adds a subcommand for the entry point.
+func (h *Host) RegisterEntryPoint(info goplug.PluginInfo, entry goplug.EntryPoint, action goplug.OnOneShot) error {
+	cmd, err := NewCommand(entry, action)
+	if err != nil {
+		return err
+	}
+
+	if h.GoPlug != nil && info.Completion {
+		// Plugins without entry points are registered with the name of
+		// their ID.
+		entryPoint := entry.Name
+		if len(info.EntryPoints) == 0 {
+			entryPoint = ""
+		}
+		cmd.ValidArgsFunction = completionFunc(entry.Name, h.GoPlug.Completer(info.ID, entryPoint))
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, name := range append([]string{cmd.Name()}, cmd.Aliases...) {
+		if findCommand(h.Root, name) != nil {
+			return checkpoint.From(fmt.Errorf("PluginID: %v: %v: %w", info.ID, name, ErrCommandExists))
+		}
+	}
+
+	h.Root.AddCommand(cmd)
+	if h.commands == nil {
+		h.commands = make(map[string][]*cobra.Command)
+	}
+	h.commands[info.ID] = append(h.commands[info.ID], cmd)
+	return nil
+}
+
+// UnregisterOneShot removes all subcommands of the plugin.
+func (h *Host) UnregisterOneShot(info goplug.PluginInfo) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.Root.RemoveCommand(h.commands[info.ID]...)
+	delete(h.commands, info.ID)
+	return nil
+}
+
+// ReregisterOneShot replaces the subcommand of the old plugin.
+func (h *Host) ReregisterOneShot(old goplug.PluginInfo, info goplug.PluginInfo, action goplug.OnOneShot) error {
+	err := h.UnregisterOneShot(old)
+	if err != nil {
+		return err
+	}
+
+	return h.RegisterOneShot(info, action)
+}
+
+// CommandName returns the name of the subcommand of a plugin without entry
+// points. IDs may contain "/" and "@", which cannot be used in command
+// names, so only the last path element of the ID without the version
+// suffix is used, e.g. "plugin" for "github.com/aligator/plugin@v1".
+func CommandName(ID string) string {
+	name := path.Base(ID)
+	if i := strings.Index(name, "@"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// NewCommand creates the subcommand for an entry point.
+// The action is called with the name of the entry point, the flags which
+// were set and the remaining arguments.
+func NewCommand(entry goplug.EntryPoint, action goplug.OnOneShot) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:     entry.Name,
+		Short:   entry.Short,
+		Long:    entry.Long,
+		Aliases: entry.Aliases,
+		// Errors of the plugin are shown by the plugin itself.
+		SilenceUsage: true,
+	}
+
+	// pflag panics on invalid flags.
+	err := goplug.ValidateFlags(entry.Flags)
+	if err != nil {
+		return nil, checkpoint.From(fmt.Errorf("entry point %v: %w", entry.Name, err))
+	}
+
+	for _, flag := range entry.Flags {
+		err := addFlag(cmd, flag)
+		if err != nil {
+			return nil, checkpoint.From(fmt.Errorf("entry point %v: %w", entry.Name, err))
+		}
+	}
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		err := action(pluginArgs(cmd, entry.Name, args))
+
+		// Only the exit code of the plugin is passed on, as the plugin
+		// already printed its error.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			cmd.SilenceErrors = true
+		}
+		return err
+	}
+
+	return cmd, nil
+}
+
+// addFlag adds the flag to the flags of the command.
+func addFlag(cmd *cobra.Command, flag goplug.Flag) error {
+	flags := cmd.Flags()
+
+	var err error
+	switch flag.Type {
+	case "", goplug.FlagString:
+		flags.StringP(flag.Name, flag.Shorthand, flag.Default, flag.Usage)
+	case goplug.FlagBool:
+		flags.BoolP(flag.Name, flag.Shorthand, false, flag.Usage)
+	case goplug.FlagInt:
+		flags.IntP(flag.Name, flag.Shorthand, 0, flag.Usage)
+	case goplug.FlagFloat:
+		flags.Float64P(flag.Name, flag.Shorthand, 0, flag.Usage)
+	case goplug.FlagDuration:
+		flags.DurationP(flag.Name, flag.Shorthand, 0, flag.Usage)
+	default:
+		return checkpoint.From(fmt.Errorf("%v: %v: %w", flag.Name, flag.Type, ErrUnsupportedFlag))
+	}
+
+	// Parse the default value using the type of the flag.
+	if flag.Default != "" && flag.Type != "" && flag.Type != goplug.FlagString {
+		f := flags.Lookup(flag.Name)
+		err = f.Value.Set(flag.Default)
+		if err != nil {
+			return checkpoint.Wrap(fmt.Errorf("%v: %w", flag.Name, err), ErrInvalidFlagDefault)
+		}
+		f.DefValue = f.Value.String()
+	}
+
+	return nil
+}
+
+// pluginArgs returns the arguments passed to the plugin.
+// They start with the name of the entry point followed by all flags which
+// were set and the remaining arguments.
+func pluginArgs(cmd *cobra.Command, name string, args []string) []string {
+	result := []string{name}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		result = append(result, "--"+flag.Name+"="+flag.Value.String())
+	})
+
+	// Make sure the arguments are not mistaken for flags.
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			result = append(result, "--")
+			break
+		}
+	}
+
+	return append(result, args...)
+}
+
+// completionFunc returns the cobra completion which uses the completer.
+func completionFunc(name string, completer goplug.Completer) func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		completions, err := completer(append([]string{name}, args...), toComplete)
+		if err != nil {
+			cobra.CompErrorln(err.Error())
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		if len(completions) == 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+
+		var result []string
+		for _, completion := range completions {
+			if completion.Description != "" {
+				result = append(result, completion.Value+"\t"+completion.Description)
+			} else {
+				result = append(result, completion.Value)
+			}
+		}
+		return result, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// findCommand returns the subcommand of the root with the name or alias.
+func findCommand(root *cobra.Command, name string) *cobra.Command {
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// ExitCode returns the exit code for the error returned by executing the
+// root command. If a plugin exited with an error, its exit code is used.
+// If a plugin was killed after the host received a signal, 128 plus the
+// number of the signal is returned like a shell does, e.g. 130 for SIGINT.
+// Otherwise 1 is returned for all errors.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+
+	var interrupted *goplug.InterruptedError
+	if errors.As(err, &interrupted) {
+		if sig, ok := interrupted.Signal.(syscall.Signal); ok {
+			return 128 + int(sig)
+		}
+	}
+	return 1
+}
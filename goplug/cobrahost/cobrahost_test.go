@@ -0,0 +1,247 @@
+package cobrahost
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/aligator/goplug/goplug"
+	"github.com/spf13/cobra"
+)
+
+func TestCommandName(t *testing.T) {
+	tests := []struct {
+		ID   string
+		want string
+	}{
+		{ID: "plugin", want: "plugin"},
+		{ID: "github.com/aligator/plugin", want: "plugin"},
+		{ID: "github.com/aligator/plugin@v1.2.0", want: "plugin"},
+		{ID: "plugin@v1", want: "plugin"},
+		{ID: "@plugin", want: "@plugin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ID, func(t *testing.T) {
+			if got := CommandName(tt.ID); got != tt.want {
+				t.Errorf("CommandName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterOneShot(t *testing.T) {
+	root := &cobra.Command{Use: "host"}
+	h := New(root)
+
+	var gotArgs []string
+	info := goplug.PluginInfo{
+		ID:          "github.com/aligator/plugin@v1",
+		Description: "description",
+	}
+	err := h.RegisterOneShot(info, func(args []string) error {
+		gotArgs = args
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := findCommand(root, "plugin")
+	if cmd == nil {
+		t.Fatal("the command plugin was not added")
+	}
+	if cmd.Short != info.Description {
+		t.Errorf("Short = %v, want %v", cmd.Short, info.Description)
+	}
+
+	root.SetArgs([]string{"plugin", "arg"})
+	err = root.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"plugin", "arg"}; !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("args = %v, want %v", gotArgs, want)
+	}
+
+	// Another version of the plugin results in the same command name.
+	err = h.RegisterOneShot(goplug.PluginInfo{ID: "github.com/other/plugin@v2"}, func(args []string) error {
+		return nil
+	})
+	if !errors.Is(err, ErrCommandExists) {
+		t.Errorf("RegisterOneShot() error = %v, want %v", err, ErrCommandExists)
+	}
+
+	err = h.UnregisterOneShot(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findCommand(root, "plugin") != nil {
+		t.Error("the command plugin was not removed")
+	}
+}
+
+func TestPluginArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []goplug.Flag
+		args  []string
+		want  []string
+	}{
+		{
+			name: "no arguments",
+			want: []string{"entry"},
+		},
+		{
+			name: "arguments",
+			args: []string{"a", "b"},
+			want: []string{"entry", "a", "b"},
+		},
+		{
+			name:  "flags",
+			flags: []goplug.Flag{{Name: "name"}, {Name: "force", Shorthand: "f", Type: goplug.FlagBool}, {Name: "unset"}},
+			args:  []string{"--name", "value", "-f", "a"},
+			want:  []string{"entry", "--force=true", "--name=value", "a"},
+		},
+		{
+			name: "arguments looking like flags",
+			args: []string{"a", "--", "-b"},
+			want: []string{"entry", "--", "a", "-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			cmd, err := NewCommand(goplug.EntryPoint{Name: "entry", Flags: tt.flags}, func(args []string) error {
+				got = args
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd.SetArgs(tt.args)
+			err = cmd.Execute()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pluginArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddFlagDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		flag        goplug.Flag
+		wantDefault string
+		wantErr     error
+	}{
+		{name: "string", flag: goplug.Flag{Name: "f", Default: "value"}, wantDefault: "value"},
+		{name: "explicit string", flag: goplug.Flag{Name: "f", Type: goplug.FlagString, Default: "value"}, wantDefault: "value"},
+		{name: "bool", flag: goplug.Flag{Name: "f", Type: goplug.FlagBool, Default: "true"}, wantDefault: "true"},
+		{name: "bool without default", flag: goplug.Flag{Name: "f", Type: goplug.FlagBool}, wantDefault: "false"},
+		{name: "int", flag: goplug.Flag{Name: "f", Type: goplug.FlagInt, Default: "42"}, wantDefault: "42"},
+		{name: "float", flag: goplug.Flag{Name: "f", Type: goplug.FlagFloat, Default: "1.5"}, wantDefault: "1.5"},
+		{name: "duration", flag: goplug.Flag{Name: "f", Type: goplug.FlagDuration, Default: "90s"}, wantDefault: "1m30s"},
+		{name: "invalid int", flag: goplug.Flag{Name: "f", Type: goplug.FlagInt, Default: "many"}, wantErr: ErrInvalidFlagDefault},
+		{name: "invalid duration", flag: goplug.Flag{Name: "f", Type: goplug.FlagDuration, Default: "1"}, wantErr: ErrInvalidFlagDefault},
+		{name: "unsupported type", flag: goplug.Flag{Name: "f", Type: "list"}, wantErr: ErrUnsupportedFlag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			err := addFlag(cmd, tt.flag)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("addFlag() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			f := cmd.Flags().Lookup(tt.flag.Name)
+			if f.DefValue != tt.wantDefault {
+				t.Errorf("DefValue = %v, want %v", f.DefValue, tt.wantDefault)
+			}
+			if f.Value.String() != tt.wantDefault {
+				t.Errorf("Value = %v, want %v", f.Value.String(), tt.wantDefault)
+			}
+		})
+	}
+}
+
+// exitError runs the shell script and returns its *exec.ExitError.
+func exitError(t *testing.T, script string) error {
+	t.Helper()
+
+	err := exec.Command("sh", "-c", script).Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("running %q error = %v, want *exec.ExitError", script, err)
+	}
+	return err
+}
+
+func TestExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses shell scripts")
+	}
+
+	exited := exitError(t, "exit 3")
+	killed := exitError(t, "kill -9 $$")
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "nil", err: nil, want: 0},
+		{name: "other error", err: errors.New("failed"), want: 1},
+		{name: "exit error", err: exited, want: 3},
+		{name: "killed by a signal", err: killed, want: 1},
+		{
+			name: "interrupted",
+			err:  &goplug.InterruptedError{PluginID: "plugin", Signal: syscall.SIGINT},
+			want: 130,
+		},
+		{
+			name: "interrupted and killed",
+			err:  &goplug.InterruptedError{PluginID: "plugin", Signal: syscall.SIGTERM, Err: killed},
+			want: 143,
+		},
+		{
+			name: "interrupted and exited",
+			err:  &goplug.InterruptedError{PluginID: "plugin", Signal: syscall.SIGINT, Err: exited},
+			want: 3,
+		},
+		{
+			name: "interrupted by an unknown signal",
+			err:  &goplug.InterruptedError{PluginID: "plugin", Signal: unknownSignal{}},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// unknownSignal is an os.Signal which is no syscall.Signal.
+type unknownSignal struct{}
+
+func (unknownSignal) String() string { return "unknown" }
+func (unknownSignal) Signal()        {}
+
+var _ os.Signal = unknownSignal{}
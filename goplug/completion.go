@@ -0,0 +1,201 @@
+// File completion.go contains the shell completion which is delegated to
+// the plugins. To be fast, the plugin is not connected using jsonrpc for
+// it. Instead, it is started in completion mode, prints the completions as
+// json to stdout and exits.
+
+package goplug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrCompletion = errors.New("could not get the completions of the plugin")
+)
+
+// DefaultCompletionTimeout is used if GoPlug.CompletionTimeout is not set.
+const DefaultCompletionTimeout = 2 * time.Second
+
+// CompleteCommand is the hidden command used by the script returned by
+// BashCompletionScript to ask the host for completions.
+const CompleteCommand = "__complete"
+
+// Completion is a suggestion for the word which gets completed.
+type Completion struct {
+	Value string `json:"value"`
+
+	// Description is optional and shown by shells which support it.
+	Description string `json:"description,omitempty"`
+}
+
+// CompletionRequest is passed to the CompletionHandler of a plugin.
+type CompletionRequest struct {
+	// EntryPoint is the name of the entry point which gets completed.
+	// It is empty if the plugin has no entry points.
+	EntryPoint string
+
+	// Args contains the arguments before the word which gets completed.
+	Args []string
+
+	// ToComplete is the partial word which gets completed.
+	ToComplete string
+}
+
+// CompletionHandler returns the completions for the request.
+type CompletionHandler func(request CompletionRequest) ([]Completion, error)
+
+// Completer returns the completions for the arguments of a plugin.
+// It can be used by the host to implement the completion of the
+// subcommands of the plugins.
+type Completer func(args []string, toComplete string) ([]Completion, error)
+
+// OnComplete sets the handler which returns the completions for the
+// arguments of the plugin. It has to be called before Init.
+func (c *Client) OnComplete(handler CompletionHandler) {
+	c.completionHandler = handler
+	c.Completion = true
+}
+
+// complete prints the completions for the arguments the plugin was started
+// with as json to stdout.
+// The last argument is the word which gets completed.
+func (c *Client) complete() error {
+	request := CompletionRequest{
+		EntryPoint: c.entryPoint,
+	}
+	if len(os.Args) > 1 {
+		request.Args = os.Args[1 : len(os.Args)-1]
+		request.ToComplete = os.Args[len(os.Args)-1]
+	}
+
+	completions := []Completion{}
+	if c.completionHandler != nil {
+		result, err := c.completionHandler(request)
+		if err != nil {
+			return err
+		}
+		completions = append(completions, result...)
+	}
+
+	res, err := json.Marshal(completions)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+	fmt.Print(string(res))
+	return nil
+}
+
+// Complete asks the plugin for the completions of the partial word
+// toComplete, which follows the args.
+// The entryPoint has to be set for plugins with entry points.
+// Plugins which do not support completion return no completions.
+func (g *GoPlug) Complete(ctx context.Context, ID string, entryPoint string, args []string, toComplete string) ([]Completion, error) {
+	g.oneShotPluginsMutex.Lock()
+	p, ok := g.oneShotPlugins[ID]
+	g.oneShotPluginsMutex.Unlock()
+	if !ok {
+		return nil, checkpoint.From(fmt.Errorf("PluginID: %v: %w", ID, ErrPluginDoesNotExist))
+	}
+
+	if entryPoint != "" && !p.hasEntryPoint(entryPoint) {
+		return nil, checkpoint.From(fmt.Errorf("PluginID: %v: %v: %w", ID, entryPoint, ErrEntryPointDoesNotExist))
+	}
+
+	if !p.Completion {
+		return nil, nil
+	}
+
+	err := g.activate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, p.filePath, append(append([]string{}, args...), toComplete)...)
+	cmd.Env = append(os.Environ(), modeEnv+"="+completeMode)
+	if env := g.configEnviron(p); env != "" {
+		cmd.Env = append(cmd.Env, env)
+	}
+	if entryPoint != "" {
+		cmd.Env = append(cmd.Env, entryPointEnv+"="+entryPoint)
+	}
+	setProcessAttributes(cmd, false)
+	cmd.Stderr = os.Stderr
+
+	res, err := output(ctx, cmd)
+	if err != nil {
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCompletion)
+	}
+
+	var completions []Completion
+	err = json.Unmarshal(res, &completions)
+	if err != nil {
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCompletion)
+	}
+	return completions, nil
+}
+
+// Completer returns a Completer for the plugin which uses the
+// CompletionTimeout.
+// The entryPoint has to be set for plugins with entry points.
+func (g *GoPlug) Completer(ID string, entryPoint string) Completer {
+	return func(args []string, toComplete string) ([]Completion, error) {
+		timeout := g.CompletionTimeout
+		if timeout <= 0 {
+			timeout = DefaultCompletionTimeout
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return g.Complete(ctx, ID, entryPoint, args, toComplete)
+	}
+}
+
+// WriteCompletions writes one completion per line to w.
+// The description is separated by a tab.
+// This is the format read by the script returned by BashCompletionScript.
+func WriteCompletions(w io.Writer, completions []Completion) error {
+	for _, completion := range completions {
+		line := completion.Value
+		if completion.Description != "" {
+			line += "\t" + completion.Description
+		}
+
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return checkpoint.From(err)
+		}
+	}
+	return nil
+}
+
+// BashCompletionScript returns a bash completion script for the program.
+// On each completion, the script calls
+//
+//	program __complete <args...> <word to complete>
+//
+// The host has to handle the CompleteCommand, e.g. by passing the
+// remaining arguments to the Completer of the invoked plugin and writing
+// the result using WriteCompletions.
+func BashCompletionScript(program string) string {
+	name := strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(program)
+	return fmt.Sprintf(`# bash completion for %[1]v
+_%[2]v_complete() {
+    local IFS=$'\n'
+    local words=("${COMP_WORDS[@]:1:COMP_CWORD}")
+    local completions
+    completions=$(%[1]v %[3]v "${words[@]}" 2>/dev/null | cut -f1)
+    COMPREPLY=($(compgen -W "${completions}" -- "${COMP_WORDS[COMP_CWORD]}"))
+}
+complete -o default -F _%[2]v_complete %[1]v
+`, program, name, CompleteCommand)
+}
@@ -0,0 +1,156 @@
+// File config.go contains the configuration which the host passes to the
+// plugins. Plugins can declare a JSON Schema for it, which is validated
+// during the initialization.
+
+package goplug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aligator/checkpoint"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid plugin config")
+)
+
+// configEnv is the environment variable which passes the config as json
+// to the plugin in completion mode. Connected plugins request the config
+// through the rpc instead, so it is not subject to the size limits of the
+// environment.
+const configEnv = "GOPLUG_CONFIG"
+
+// loadConfig reads the ConfigFile of GoPlug.
+// It may be a json or yaml (".yaml" / ".yml") file which contains the
+// config of each plugin keyed by the plugin ID.
+func (g *GoPlug) loadConfig() error {
+	g.configs = make(map[string]json.RawMessage)
+	if g.ConfigFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(g.ConfigFile)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	switch strings.ToLower(filepath.Ext(g.ConfigFile)) {
+	case ".yaml", ".yml":
+		var configs map[string]interface{}
+		err = yaml.Unmarshal(data, &configs)
+		if err != nil {
+			return checkpoint.Wrap(err, ErrInvalidConfig)
+		}
+
+		// Convert the config of each plugin to json.
+		for ID, config := range configs {
+			configJson, err := json.Marshal(config)
+			if err != nil {
+				return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrInvalidConfig)
+			}
+			g.configs[ID] = configJson
+		}
+	default:
+		err = json.Unmarshal(data, &g.configs)
+		if err != nil {
+			return checkpoint.Wrap(err, ErrInvalidConfig)
+		}
+	}
+
+	return nil
+}
+
+// validateConfig checks the config of the plugin against the ConfigSchema
+// of the plugin. If the host has no config for the plugin, an empty
+// object is validated.
+func (g *GoPlug) validateConfig(p *plugin) error {
+	if len(p.ConfigSchema) == 0 {
+		return nil
+	}
+
+	schema, err := jsonschema.CompileString(p.ID+"/config.schema.json", string(p.ConfigSchema))
+	if err != nil {
+		return checkpoint.Wrap(fmt.Errorf("PluginID: %v: invalid schema: %w", p.ID, err), ErrInvalidConfig)
+	}
+
+	config, ok := g.configs[p.ID]
+	if !ok {
+		config = json.RawMessage("{}")
+	}
+
+	var value interface{}
+	err = json.Unmarshal(config, &value)
+	if err != nil {
+		return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", p.ID, err), ErrInvalidConfig)
+	}
+
+	err = schema.Validate(value)
+	if err != nil {
+		return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", p.ID, err), ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// configEnviron returns the environment variable which passes the config to
+// the plugin in completion mode. It returns an empty string if there is no
+// config.
+func (g *GoPlug) configEnviron(p *plugin) string {
+	config, ok := g.configs[p.ID]
+	if !ok {
+		return ""
+	}
+
+	return configEnv + "=" + string(config)
+}
+
+type ConfigRequest struct{}
+
+type ConfigResponse struct {
+	Config json.RawMessage
+}
+
+// Config returns the config of the plugin.
+func (h *HostControl) Config(args ConfigRequest, reply *ConfigResponse) error {
+	*reply = ConfigResponse{
+		Config: h.GoPlug.configs[h.plugin.ID],
+	}
+	return nil
+}
+
+// Config unmarshals the config which the host passed to the plugin into v.
+// It is only available after Init.
+// If the host has no config for the plugin, v is not changed.
+func (c *Client) Config(v interface{}) error {
+	config := c.config
+	if c.client != nil {
+		response := ConfigResponse{}
+		err := c.client.Call("HostControl.Config", ConfigRequest{}, &response)
+		if err != nil {
+			return err
+		}
+		config = response.Config
+	}
+
+	if len(config) == 0 {
+		return nil
+	}
+
+	return checkpoint.From(json.Unmarshal(config, v))
+}
+
+// readConfig reads the config passed in completion mode from the
+// environment. It is removed from the environment so that processes
+// started by the plugin do not inherit it, as it may contain secrets.
+func (c *Client) readConfig() {
+	c.config = json.RawMessage(os.Getenv(configEnv))
+	_ = os.Unsetenv(configEnv)
+}
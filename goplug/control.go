@@ -0,0 +1,322 @@
+// File control.go contains the control messages which the host sends to
+// each running plugin process to drive its lifecycle.
+
+package goplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrPluginExited = errors.New("plugin exited")
+)
+
+// ControlKind defines what a ControlMessage requests from the plugin.
+type ControlKind string
+
+const (
+	// ControlStart is the first message each plugin process receives.
+	ControlStart = ControlKind("start")
+
+	// ControlStop asks the plugin to stop. The plugin should exit after
+	// acknowledging it.
+	ControlStop = ControlKind("stop")
+
+	// ControlHostShutdown informs the plugin that the host shuts down.
+	ControlHostShutdown = ControlKind("host_shutdown")
+)
+
+// ControlMessage is sent from the host to a plugin process.
+// The plugin receives it by HostControl.NextControl and has to acknowledge
+// it by HostControl.AckControl.
+type ControlMessage struct {
+	ID     uint64      `json:"id"`
+	Kind   ControlKind `json:"kind"`
+	Reason Reason      `json:"reason,omitempty"`
+}
+
+// controlDelivery is the host side of a ControlMessage.
+type controlDelivery struct {
+	ControlMessage
+
+	// result receives the error sent by the plugin exactly once.
+	result chan error
+}
+
+func newControlDelivery(kind ControlKind, reason Reason) *controlDelivery {
+	return &controlDelivery{
+		ControlMessage: ControlMessage{
+			ID:     nextMessageID(),
+			Kind:   kind,
+			Reason: reason,
+		},
+		result: make(chan error, 1),
+	}
+}
+
+// controlQueue queues the control messages of one plugin process.
+// Messages are delivered in the order they were pushed.
+type controlQueue struct {
+	mutex sync.Mutex
+
+	// changed gets closed and replaced on each change of the queue.
+	changed chan struct{}
+
+	queue    []*controlDelivery
+	inFlight map[uint64]*controlDelivery
+}
+
+func newControlQueue() *controlQueue {
+	return &controlQueue{
+		changed:  make(chan struct{}),
+		inFlight: make(map[uint64]*controlDelivery),
+	}
+}
+
+func (q *controlQueue) push(d *controlDelivery) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.queue = append(q.queue, d)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// next blocks until a message is available.
+// It returns false if stop got closed.
+func (q *controlQueue) next(stop <-chan struct{}) (*controlDelivery, bool) {
+	for {
+		q.mutex.Lock()
+		if len(q.queue) > 0 {
+			d := q.queue[0]
+			q.queue = q.queue[1:]
+			q.inFlight[d.ID] = d
+			q.mutex.Unlock()
+			return d, true
+		}
+		changed := q.changed
+		q.mutex.Unlock()
+
+		select {
+		case <-stop:
+			return nil, false
+		case <-changed:
+		}
+	}
+}
+
+// ack finishes the in-flight message with the given id.
+func (q *controlQueue) ack(id uint64, err error) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	d, ok := q.inFlight[id]
+	if !ok {
+		return checkpoint.From(fmt.Errorf("control message %v is not in flight", id))
+	}
+
+	delete(q.inFlight, id)
+	d.result <- err
+	return nil
+}
+
+// sendControl sends the control message to the plugin process and waits
+// until the plugin acknowledged it, the process exited or the context is
+// done.
+func (i *instance) sendControl(ctx context.Context, kind ControlKind, reason Reason) error {
+	d := newControlDelivery(kind, reason)
+	i.control.push(d)
+
+	select {
+	case err := <-d.result:
+		return err
+	case <-i.done:
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %w", i.plugin.ID, ErrPluginExited))
+	case <-ctx.Done():
+		return checkpoint.From(ctx.Err())
+	}
+}
+
+// drain stops all running processes of the background plugin as soon as
+// they received all queued messages.
+func (g *GoPlug) drain(p *plugin, reason Reason) {
+	for _, i := range p.runningInstances() {
+		go func(i *instance) {
+			if !p.inbox.waitIdle(i.done) {
+				return
+			}
+
+			err := i.sendControl(context.Background(), ControlStop, reason)
+			if err != nil && !errors.Is(err, ErrPluginExited) {
+				log.Println(err)
+			}
+		}(i)
+	}
+}
+
+type NextControlRequest struct{}
+
+type NextControlResponse struct {
+	Message ControlMessage
+
+	// Closed is true if the plugin should stop asking for control messages.
+	Closed bool
+}
+
+// NextControl blocks until a control message for the plugin process is
+// available.
+func (h *HostControl) NextControl(args NextControlRequest, reply *NextControlResponse) error {
+	if h.control == nil {
+		return checkpoint.From(ErrNoInbox)
+	}
+
+	d, ok := h.control.next(h.done)
+	if !ok {
+		*reply = NextControlResponse{
+			Closed: true,
+		}
+		return nil
+	}
+
+	*reply = NextControlResponse{
+		Message: d.ControlMessage,
+	}
+	return nil
+}
+
+type AckControlRequest struct {
+	ID uint64
+
+	// Error is set if a handler of the plugin failed.
+	Error string
+}
+
+type AckControlResponse struct{}
+
+// AckControl acknowledges a control message received by NextControl.
+func (h *HostControl) AckControl(args AckControlRequest, reply *AckControlResponse) error {
+	if h.control == nil {
+		return checkpoint.From(ErrNoInbox)
+	}
+
+	var err error
+	if args.Error != "" {
+		err = checkpoint.Wrap(fmt.Errorf("PluginID: %v: %v", h.plugin.ID, args.Error), ErrHandlingMessage)
+	}
+
+	return h.control.ack(args.ID, err)
+}
+
+// StopHandler is called when the host stops the plugin.
+type StopHandler func(reason Reason) error
+
+// OnStart registers a handler which is called when the plugin got
+// connected to the host. Init returns after all start handlers are done.
+// It has to be called before Init.
+func (c *Client) OnStart(handler func() error) {
+	c.startHandlers = append(c.startHandlers, handler)
+}
+
+// OnStop registers a handler which is called when the host stops the
+// plugin, e.g. because the plugin got unloaded. The plugin should flush
+// its state, as it exits afterwards. Listen returns after all stop
+// handlers are done.
+// It has to be called before Init.
+func (c *Client) OnStop(handler StopHandler) {
+	c.stopHandlers = append(c.stopHandlers, handler)
+}
+
+// OnHostShutdown registers a handler which is called when the host shuts
+// down. The plugin gets stopped afterwards.
+// It has to be called before Init.
+func (c *Client) OnHostShutdown(handler func() error) {
+	c.hostShutdownHandlers = append(c.hostShutdownHandlers, handler)
+}
+
+// receiveControl receives and handles the next control message.
+// It returns false if there are no more control messages.
+func (c *Client) receiveControl() (bool, error) {
+	res := NextControlResponse{}
+	err := c.client.Call("HostControl.NextControl", NextControlRequest{}, &res)
+	if isConnectionClosed(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if res.Closed {
+		return false, nil
+	}
+
+	handlerErr := c.handleControl(res.Message)
+
+	ack := AckControlRequest{
+		ID: res.Message.ID,
+	}
+	if handlerErr != nil {
+		ack.Error = handlerErr.Error()
+	}
+	err = c.client.Call("HostControl.AckControl", ack, &AckControlResponse{})
+	if isConnectionClosed(err) {
+		return false, handlerErr
+	} else if err != nil {
+		return false, err
+	}
+
+	if res.Message.Kind == ControlStop {
+		c.stopOnce.Do(func() {
+			close(c.stopped)
+		})
+		return false, handlerErr
+	}
+
+	return true, handlerErr
+}
+
+// handleControl calls all handlers registered for the control message.
+// All handlers are called even if one fails. The first error is returned.
+func (c *Client) handleControl(m ControlMessage) error {
+	var firstErr error
+	collect := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	switch m.Kind {
+	case ControlStart:
+		for _, handler := range c.startHandlers {
+			collect(handler())
+		}
+	case ControlStop:
+		for _, handler := range c.stopHandlers {
+			collect(handler(m.Reason))
+		}
+	case ControlHostShutdown:
+		for _, handler := range c.hostShutdownHandlers {
+			collect(handler())
+		}
+	}
+
+	return firstErr
+}
+
+// serveControl handles the control messages until the plugin gets stopped
+// or the connection gets closed.
+func (c *Client) serveControl() {
+	for {
+		ok, err := c.receiveControl()
+		if err != nil {
+			log.Println(c.ID, "- control message failed:", err)
+		}
+		if !ok {
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// File dependencies.go contains the dependency resolution between plugins.
+// Plugins can require other plugins, which are then registered before them.
+
+package goplug
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrMissingDependency     = errors.New("dependency does not exist")
+	ErrUnsatisfiedDependency = errors.New("dependency is not satisfied")
+	ErrDependencyCycle       = errors.New("dependency cycle detected")
+)
+
+// Dependency is a plugin required by another plugin.
+type Dependency struct {
+	// ID of the required plugin.
+	ID string `json:"id"`
+
+	// Version is the range of compatible versions of the required plugin.
+	// See VersionMatches for the syntax. If it is empty, all versions
+	// are accepted.
+	Version string `json:"version,omitempty"`
+}
+
+// checkDependency checks if the dependency is available in a
+// compatible version.
+func checkDependency(p *plugin, dep Dependency, byID map[string]*plugin) error {
+	target, ok := byID[dep.ID]
+	if !ok {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: requires %v: %w", p.ID, dep.ID, ErrMissingDependency))
+	}
+
+	if dep.Version == "" {
+		return nil
+	}
+
+	if target.Version == "" {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: requires %v %v but it has no version: %w", p.ID, dep.ID, dep.Version, ErrUnsatisfiedDependency))
+	}
+
+	matches, err := VersionMatches(target.Version, dep.Version)
+	if err != nil {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: requires %v: %w", p.ID, dep.ID, err))
+	}
+
+	if !matches {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: requires %v %v but found %v: %w", p.ID, dep.ID, dep.Version, target.Version, ErrUnsatisfiedDependency))
+	}
+
+	return nil
+}
+
+// pluginError is an error which belongs to a plugin.
+type pluginError struct {
+	plugin *plugin
+	err    error
+}
+
+// sortByDependencies orders the plugins so that each plugin comes after
+// all of its dependencies. Plugins without dependencies between them are
+// sorted by their ID, so the order does not depend on the order in which
+// the plugins were discovered.
+//
+// Plugins with missing or incompatible dependencies, plugins in a
+// dependency cycle and all plugins depending on them are left out.
+// If several plugins use the same ID, only the first one by path is kept.
+// An error is returned for each plugin which is left out.
+func sortByDependencies(plugins []*plugin) ([]*plugin, []pluginError) {
+	var errs []pluginError
+
+	plugins = append([]*plugin(nil), plugins...)
+	sort.SliceStable(plugins, func(i, j int) bool {
+		if plugins[i].ID != plugins[j].ID {
+			return plugins[i].ID < plugins[j].ID
+		}
+		return plugins[i].filePath < plugins[j].filePath
+	})
+
+	plugins, errs = duplicateIDs(plugins)
+
+	byID := make(map[string]*plugin)
+	for _, p := range plugins {
+		byID[p.ID] = p
+	}
+
+	// Remove all plugins whose dependencies are not available.
+	unsatisfied := make(map[string]bool)
+	for _, p := range plugins {
+		for _, dep := range p.Dependencies {
+			err := checkDependency(p, dep, byID)
+			if err != nil {
+				errs = append(errs, pluginError{p, err})
+				unsatisfied[p.ID] = true
+				break
+			}
+		}
+	}
+
+	// Also remove all plugins which depend on removed plugins.
+	for changed := true; changed; {
+		changed = false
+		for _, p := range plugins {
+			if unsatisfied[p.ID] {
+				continue
+			}
+
+			for _, dep := range p.Dependencies {
+				if unsatisfied[dep.ID] {
+					errs = append(errs, pluginError{p, checkpoint.From(fmt.Errorf("PluginID: %v: requires %v: %w", p.ID, dep.ID, ErrUnsatisfiedDependency))})
+					unsatisfied[p.ID] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	// Add the plugins as soon as all of their dependencies are added.
+	sorted := make([]*plugin, 0, len(plugins))
+	added := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, p := range plugins {
+			if unsatisfied[p.ID] || added[p.ID] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range p.Dependencies {
+				if !added[dep.ID] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				sorted = append(sorted, p)
+				added[p.ID] = true
+				changed = true
+			}
+		}
+	}
+
+	// All plugins which are left are part of a cycle or depend on one.
+	for _, p := range plugins {
+		if unsatisfied[p.ID] || added[p.ID] {
+			continue
+		}
+
+		var waiting []string
+		for _, dep := range p.Dependencies {
+			if !added[dep.ID] {
+				waiting = append(waiting, dep.ID)
+			}
+		}
+		errs = append(errs, pluginError{p, checkpoint.From(fmt.Errorf("PluginID: %v: waits for %v: %w", p.ID, strings.Join(waiting, ", "), ErrDependencyCycle))})
+	}
+
+	return sorted, errs
+}
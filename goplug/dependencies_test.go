@@ -0,0 +1,192 @@
+package goplug
+
+import (
+	"errors"
+	"testing"
+)
+
+// testPlugin returns a Listener plugin with the dependencies.
+func testPlugin(ID string, filePath string, dependencies ...Dependency) *plugin {
+	return &plugin{
+		PluginInfo: PluginInfo{
+			ID:           ID,
+			PluginType:   Listener,
+			Dependencies: dependencies,
+		},
+		filePath: filePath,
+	}
+}
+
+// testVersion sets the version of the plugin.
+func testVersion(p *plugin, version string) *plugin {
+	p.Version = version
+	return p
+}
+
+func pluginPaths(plugins []*plugin) []string {
+	var paths []string
+	for _, p := range plugins {
+		paths = append(paths, p.filePath)
+	}
+	return paths
+}
+
+func errorPaths(errs []pluginError) []string {
+	var paths []string
+	for _, e := range errs {
+		paths = append(paths, e.plugin.filePath)
+	}
+	return paths
+}
+
+func assertPaths(t *testing.T, name string, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%v = %v, want %v", name, got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("%v = %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+func TestSortByDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		plugins    []*plugin
+		wantSorted []string
+		wantErrs   map[string]error
+	}{
+		{
+			name: "no plugins",
+		},
+		{
+			name:       "sorted by id",
+			plugins:    []*plugin{testPlugin("c", "/c"), testPlugin("a", "/a"), testPlugin("b", "/b")},
+			wantSorted: []string{"/a", "/b", "/c"},
+		},
+		{
+			name: "dependencies first",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "c"}),
+				testPlugin("b", "/b"),
+				testPlugin("c", "/c", Dependency{ID: "b"}),
+			},
+			wantSorted: []string{"/b", "/c", "/a"},
+		},
+		{
+			name: "matching version",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "b", Version: "^1.2.0"}),
+				testVersion(testPlugin("b", "/b"), "1.4.0"),
+			},
+			wantSorted: []string{"/b", "/a"},
+		},
+		{
+			name: "missing dependency",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "x"}),
+				testPlugin("b", "/b"),
+			},
+			wantSorted: []string{"/b"},
+			wantErrs:   map[string]error{"/a": ErrMissingDependency},
+		},
+		{
+			name: "incompatible version",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "b", Version: "^1.2.0"}),
+				testVersion(testPlugin("b", "/b"), "2.0.0"),
+			},
+			wantSorted: []string{"/b"},
+			wantErrs:   map[string]error{"/a": ErrUnsatisfiedDependency},
+		},
+		{
+			name: "dependency without version",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "b", Version: "1.0.0"}),
+				testPlugin("b", "/b"),
+			},
+			wantSorted: []string{"/b"},
+			wantErrs:   map[string]error{"/a": ErrUnsatisfiedDependency},
+		},
+		{
+			name: "invalid version range",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "b", Version: "=>1.0.0"}),
+				testVersion(testPlugin("b", "/b"), "1.0.0"),
+			},
+			wantSorted: []string{"/b"},
+			wantErrs:   map[string]error{"/a": ErrInvalidVersion},
+		},
+		{
+			name: "transitive missing dependency",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "b"}),
+				testPlugin("b", "/b", Dependency{ID: "x"}),
+				testPlugin("c", "/c"),
+			},
+			wantSorted: []string{"/c"},
+			wantErrs: map[string]error{
+				"/a": ErrUnsatisfiedDependency,
+				"/b": ErrMissingDependency,
+			},
+		},
+		{
+			name: "cycle",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "b"}),
+				testPlugin("b", "/b", Dependency{ID: "a"}),
+				testPlugin("c", "/c", Dependency{ID: "a"}),
+				testPlugin("d", "/d"),
+			},
+			wantSorted: []string{"/d"},
+			wantErrs: map[string]error{
+				"/a": ErrDependencyCycle,
+				"/b": ErrDependencyCycle,
+				"/c": ErrDependencyCycle,
+			},
+		},
+		{
+			name: "self dependency",
+			plugins: []*plugin{
+				testPlugin("a", "/a", Dependency{ID: "a"}),
+			},
+			wantErrs: map[string]error{"/a": ErrDependencyCycle},
+		},
+		{
+			name: "duplicate id",
+			plugins: []*plugin{
+				testPlugin("a", "/a2"),
+				testPlugin("b", "/b", Dependency{ID: "a"}),
+				testPlugin("a", "/a1"),
+			},
+			wantSorted: []string{"/a1", "/b"},
+			wantErrs:   map[string]error{"/a2": ErrDuplicatePluginID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, errs := sortByDependencies(tt.plugins)
+
+			assertPaths(t, "sorted", pluginPaths(sorted), tt.wantSorted)
+
+			if len(errs) != len(tt.wantErrs) {
+				t.Errorf("errors = %v, want %v", errorPaths(errs), tt.wantErrs)
+			}
+			for _, e := range errs {
+				want, ok := tt.wantErrs[e.plugin.filePath]
+				if !ok {
+					t.Errorf("unexpected error of %v: %v", e.plugin.filePath, e.err)
+					continue
+				}
+				if !errors.Is(e.err, want) {
+					t.Errorf("error of %v = %v, want %v", e.plugin.filePath, e.err, want)
+				}
+			}
+		})
+	}
+}
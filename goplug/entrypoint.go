@@ -0,0 +1,242 @@
+// File entrypoint.go contains the entry points of OneShot plugins.
+// A plugin can provide several entry points, e.g. one for each subcommand.
+// Each of them is registered separately at the host.
+
+package goplug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"unicode"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrEntryPointDoesNotExist = errors.New("entry point does not exist")
+	ErrDuplicateEntryPoint    = errors.New("entry point is defined more than once")
+	ErrNoEntryPoint           = errors.New("the plugin was not invoked through an entry point")
+	ErrInvalidFlag            = errors.New("invalid flag")
+)
+
+// entryPointEnv is the environment variable which passes the name of the
+// invoked entry point to the plugin.
+const entryPointEnv = "GOPLUG_ENTRY_POINT"
+
+// FlagType is the type of the value of a Flag.
+type FlagType string
+
+const (
+	FlagString   = FlagType("string")
+	FlagBool     = FlagType("bool")
+	FlagInt      = FlagType("int")
+	FlagFloat    = FlagType("float")
+	FlagDuration = FlagType("duration")
+)
+
+// Flag is a command line flag accepted by an entry point.
+// Hosts can use it to show help and validate the arguments, but the plugin
+// still has to parse the arguments by itself.
+type Flag struct {
+	Name string `json:"name"`
+
+	// Shorthand is an optional one-letter abbreviation of the flag.
+	Shorthand string `json:"shorthand,omitempty"`
+
+	Usage string `json:"usage,omitempty"`
+
+	// Type is FlagString if it is empty.
+	Type FlagType `json:"type,omitempty"`
+
+	// Default is the default value formatted as string.
+	Default string `json:"default,omitempty"`
+}
+
+// ValidateFlags checks if the flags can be used by hosts.
+// The names and shorthands have to be unique and a shorthand has to be a
+// single ASCII character. The help flag is reserved for the host.
+func ValidateFlags(flags []Flag) error {
+	names := make(map[string]bool)
+	shorthands := make(map[string]bool)
+	for _, flag := range flags {
+		if flag.Name == "" {
+			return checkpoint.Wrap(errors.New("the name is empty"), ErrInvalidFlag)
+		}
+		if flag.Name == "help" || flag.Shorthand == "h" {
+			return checkpoint.Wrap(fmt.Errorf("%v: help and h are reserved", flag.Name), ErrInvalidFlag)
+		}
+		if names[flag.Name] {
+			return checkpoint.Wrap(fmt.Errorf("%v: the name is used more than once", flag.Name), ErrInvalidFlag)
+		}
+		names[flag.Name] = true
+
+		if flag.Shorthand == "" {
+			continue
+		}
+		if len(flag.Shorthand) > 1 || flag.Shorthand[0] > unicode.MaxASCII {
+			return checkpoint.Wrap(fmt.Errorf("%v: the shorthand %q is no single ASCII character", flag.Name, flag.Shorthand), ErrInvalidFlag)
+		}
+		if shorthands[flag.Shorthand] {
+			return checkpoint.Wrap(fmt.Errorf("%v: the shorthand %v is used more than once", flag.Name, flag.Shorthand), ErrInvalidFlag)
+		}
+		shorthands[flag.Shorthand] = true
+	}
+
+	return nil
+}
+
+// EntryPoint is a named way to invoke a OneShot plugin.
+type EntryPoint struct {
+	// Name must not be empty and has to be unique inside of the plugin.
+	Name string `json:"name"`
+
+	// Short and Long are the help texts of the entry point.
+	Short string `json:"short,omitempty"`
+	Long  string `json:"long,omitempty"`
+
+	// Aliases are alternative names hosts may use for the entry point.
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Flags contains the command line flags of the entry point.
+	Flags []Flag `json:"flags,omitempty"`
+
+	// Metadata is custom information about the entry point for the host,
+	// like PluginInfo.Metadata.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// EncodeMetadata sets the Metadata to v encoded as json.
+func (e *EntryPoint) EncodeMetadata(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	e.Metadata = data
+	return nil
+}
+
+// DecodeMetadata unmarshals the Metadata into v.
+// If no Metadata is set, v is not changed.
+func (e EntryPoint) DecodeMetadata(v interface{}) error {
+	if len(e.Metadata) == 0 {
+		return nil
+	}
+
+	return checkpoint.From(json.Unmarshal(e.Metadata, v))
+}
+
+// EntryPointHandler handles the invocation of an entry point.
+// It receives the arguments the plugin was started with.
+type EntryPointHandler func(args []string) error
+
+// registerEntryPoints registers each entry point of the plugin at the host.
+// If the host does not implement EntryPointHost, Host.RegisterOneShot is
+// called for each entry point instead, with the Metadata of the entry point
+// as Metadata of the plugin.
+// If the host refuses an entry point, the already registered ones are
+// unregistered again if the host implements ReloadHost.
+func (g *GoPlug) registerEntryPoints(p *plugin) error {
+	names := make(map[string]bool)
+	for _, entry := range p.EntryPoints {
+		if names[entry.Name] {
+			return checkpoint.From(fmt.Errorf("PluginID: %v: %v: %w", p.ID, entry.Name, ErrDuplicateEntryPoint))
+		}
+		names[entry.Name] = true
+	}
+
+	entryPointHost, ok := g.Host.(EntryPointHost)
+	for n, entry := range p.EntryPoints {
+		action := g.entryPointAction(p.ID, entry.Name)
+
+		var err error
+		if ok {
+			err = entryPointHost.RegisterEntryPoint(p.PluginInfo, entry, action)
+		} else {
+			info := p.PluginInfo
+			if len(entry.Metadata) > 0 {
+				info.Metadata = entry.Metadata
+			}
+			err = g.Host.RegisterOneShot(info, action)
+		}
+
+		if err != nil {
+			if reloadHost, ok := g.Host.(ReloadHost); ok && n > 0 {
+				g.logError(checkpoint.From(reloadHost.UnregisterOneShot(p.PluginInfo)))
+			}
+			return checkpoint.From(fmt.Errorf("PluginID: %v: entry point %v: %w", p.ID, entry.Name, err))
+		}
+	}
+
+	return nil
+}
+
+// entryPointAction returns the action which is passed to the host to
+// invoke the entry point of a OneShot plugin.
+func (g *GoPlug) entryPointAction(ID string, name string) OnOneShot {
+	return func(args []string) error {
+		return g.oneShot(ID, name, args)
+	}
+}
+
+// hasEntryPoint checks if the plugin provides the entry point.
+func (p *plugin) hasEntryPoint(name string) bool {
+	for _, entry := range p.EntryPoints {
+		if entry.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddEntryPoint adds an entry point to the plugin.
+// It has to be called before Init.
+// Use Dispatch to call the handler of the invoked entry point.
+// It returns ErrDuplicateEntryPoint if an entry point with the same name
+// was already added.
+func (c *Client) AddEntryPoint(entry EntryPoint, handler EntryPointHandler) error {
+	if _, ok := c.entryPointHandlers[entry.Name]; ok {
+		return checkpoint.From(fmt.Errorf("%v: %w", entry.Name, ErrDuplicateEntryPoint))
+	}
+
+	if c.entryPointHandlers == nil {
+		c.entryPointHandlers = make(map[string]EntryPointHandler)
+	}
+
+	c.entryPointHandlers[entry.Name] = handler
+	c.EntryPoints = append(c.EntryPoints, entry)
+	return nil
+}
+
+// EntryPoint returns the name of the entry point the host invoked.
+// It is empty if the plugin was not invoked through an entry point.
+// It is only available after Init.
+func (c *Client) EntryPoint() string {
+	return c.entryPoint
+}
+
+// Dispatch calls the handler of the invoked entry point with the args.
+// It returns ErrNoEntryPoint if the plugin was not invoked through an
+// entry point.
+func (c *Client) Dispatch(args []string) error {
+	if c.entryPoint == "" {
+		return checkpoint.From(ErrNoEntryPoint)
+	}
+
+	handler, ok := c.entryPointHandlers[c.entryPoint]
+	if !ok {
+		return checkpoint.From(fmt.Errorf("%v: %w", c.entryPoint, ErrEntryPointDoesNotExist))
+	}
+
+	return handler(args)
+}
+
+// readEntryPoint reads the invoked entry point from the environment.
+// It is removed from the environment so that processes started by the
+// plugin do not inherit it.
+func (c *Client) readEntryPoint() {
+	c.entryPoint = os.Getenv(entryPointEnv)
+	_ = os.Unsetenv(entryPointEnv)
+}
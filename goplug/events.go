@@ -0,0 +1,620 @@
+// File events.go contains the event bus which is used to publish host events
+// to Listener plugins.
+
+package goplug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrInboxClosed     = errors.New("inbox is closed")
+	ErrNoInbox         = errors.New("plugin has no inbox")
+	ErrHandlingMessage = errors.New("plugin could not handle the message")
+	ErrNoHandler       = errors.New("no handler registered")
+)
+
+// DefaultMaxDeliveryAttempts is used if GoPlug.MaxDeliveryAttempts is not set.
+const DefaultMaxDeliveryAttempts = 3
+
+// DefaultRestartBackoff is used if GoPlug.RestartBackoff is not set.
+const DefaultRestartBackoff = 100 * time.Millisecond
+
+// maxRestartBackoff limits the time between two starts of a plugin.
+const maxRestartBackoff = 10 * time.Second
+
+// MessageKind defines what a Message contains.
+type MessageKind string
+
+const (
+	// EventMessage contains an event published by the host.
+	EventMessage = MessageKind("event")
+
+	// FilterMessage contains a value which should be filtered.
+	// The plugin has to send the modified value back.
+	FilterMessage = MessageKind("filter")
+
+	// CallMessage contains a call of a service from another plugin.
+	// The plugin has to send the reply back.
+	CallMessage = MessageKind("call")
+)
+
+// Message is sent from the host to a plugin.
+// The plugin receives it by HostControl.Next and has to acknowledge it
+// by HostControl.Ack.
+type Message struct {
+	ID      uint64          `json:"id"`
+	Kind    MessageKind     `json:"kind"`
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+
+	// Caller is the ID of the plugin which sent a CallMessage.
+	Caller string `json:"caller,omitempty"`
+
+	// Chain contains the IDs of all plugins waiting for a CallMessage.
+	Chain []string `json:"chain,omitempty"`
+}
+
+// delivery is the host side of a Message.
+type delivery struct {
+	Message
+
+	// attempts counts how often the message was sent to the plugin.
+	attempts int
+
+	// result receives the outcome exactly once.
+	result chan ackResult
+}
+
+// ackResult is the outcome of a delivery.
+type ackResult struct {
+	// Payload may contain a result sent by the plugin.
+	Payload json.RawMessage
+	Err     error
+}
+
+func newDelivery(kind MessageKind, topic string, payload json.RawMessage) *delivery {
+	return &delivery{
+		Message: Message{
+			ID:      nextMessageID(),
+			Kind:    kind,
+			Topic:   topic,
+			Payload: payload,
+		},
+		result: make(chan ackResult, 1),
+	}
+}
+
+var (
+	lastMessageID      uint64
+	lastMessageIDMutex sync.Mutex
+)
+
+func nextMessageID() uint64 {
+	lastMessageIDMutex.Lock()
+	defer lastMessageIDMutex.Unlock()
+	lastMessageID++
+	return lastMessageID
+}
+
+// inbox queues all messages for one plugin.
+// Messages of the same topic are delivered one after another in the order
+// they were pushed. The next one is only delivered after the previous one
+// was acknowledged.
+type inbox struct {
+	mutex sync.Mutex
+
+	// changed gets closed and replaced on each change of the inbox.
+	changed chan struct{}
+
+	queue      []*delivery
+	inFlight   map[uint64]*delivery
+	busyTopics map[string]bool
+
+	// delivered counts all messages handed out by next.
+	delivered uint64
+}
+
+func newInbox() *inbox {
+	return &inbox{
+		changed:    make(chan struct{}),
+		inFlight:   make(map[uint64]*delivery),
+		busyTopics: make(map[string]bool),
+	}
+}
+
+// notify wakes up everyone waiting for changes.
+// The mutex has to be locked.
+func (b *inbox) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *inbox) push(d *delivery) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.queue = append(b.queue, d)
+	b.notify()
+}
+
+// next blocks until a message can be delivered.
+// It returns false if stop got closed.
+func (b *inbox) next(stop <-chan struct{}) (*delivery, bool) {
+	for {
+		b.mutex.Lock()
+
+		// Check stop while holding the lock, so that no message gets
+		// in-flight after the plugin exited.
+		select {
+		case <-stop:
+			b.mutex.Unlock()
+			return nil, false
+		default:
+		}
+
+		for i, d := range b.queue {
+			if b.busyTopics[d.Topic] {
+				continue
+			}
+
+			b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			b.busyTopics[d.Topic] = true
+			b.inFlight[d.ID] = d
+			d.attempts++
+			b.delivered++
+			b.mutex.Unlock()
+			return d, true
+		}
+
+		changed := b.changed
+		b.mutex.Unlock()
+
+		select {
+		case <-stop:
+			return nil, false
+		case <-changed:
+		}
+	}
+}
+
+// ack finishes the in-flight message with the given id.
+func (b *inbox) ack(id uint64, res ackResult) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	d, ok := b.inFlight[id]
+	if !ok {
+		return checkpoint.From(fmt.Errorf("message %v is not in flight", id))
+	}
+
+	delete(b.inFlight, id)
+	delete(b.busyTopics, d.Topic)
+	d.result <- res
+	b.notify()
+	return nil
+}
+
+// requeue puts all in-flight messages back to the front of the queue.
+// Messages which already reached maxAttempts fail with the given reason.
+func (b *inbox) requeue(maxAttempts int, reason error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var retry []*delivery
+	for id, d := range b.inFlight {
+		delete(b.inFlight, id)
+		delete(b.busyTopics, d.Topic)
+
+		if d.attempts >= maxAttempts {
+			d.result <- ackResult{
+				Err: checkpoint.Wrap(fmt.Errorf("message %v not acknowledged after %v attempts: %v", d.ID, d.attempts, reason), ErrHandlingMessage),
+			}
+			continue
+		}
+		retry = append(retry, d)
+	}
+
+	// Keep the original order.
+	sort.Slice(retry, func(i, j int) bool {
+		return retry[i].ID < retry[j].ID
+	})
+
+	b.queue = append(retry, b.queue...)
+	b.notify()
+}
+
+// fail finishes all queued and in-flight messages with the given error.
+func (b *inbox) fail(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for id, d := range b.inFlight {
+		delete(b.inFlight, id)
+		delete(b.busyTopics, d.Topic)
+		d.result <- ackResult{Err: err}
+	}
+
+	for _, d := range b.queue {
+		d.result <- ackResult{Err: err}
+	}
+	b.queue = nil
+	b.notify()
+}
+
+// waitIdle blocks until there are no queued or in-flight messages.
+// It returns false if stop got closed.
+func (b *inbox) waitIdle(stop <-chan struct{}) bool {
+	for {
+		b.mutex.Lock()
+		if len(b.queue) == 0 && len(b.inFlight) == 0 {
+			b.mutex.Unlock()
+			return true
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+
+		select {
+		case <-stop:
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// deliveredCount returns how many messages were handed out by next.
+func (b *inbox) deliveredCount() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.delivered
+}
+
+// pending returns true if there are queued or in-flight messages.
+func (b *inbox) pending() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.queue) > 0 || len(b.inFlight) > 0
+}
+
+// deliver pushes the message to the inbox of the plugin and makes sure
+// the plugin is running.
+func (g *GoPlug) deliver(p *plugin, d *delivery) {
+	if g.isShutdown() {
+		d.result <- ackResult{
+			Err: checkpoint.From(fmt.Errorf("PluginID: %v: %w", p.ID, ErrShutdown)),
+		}
+		return
+	}
+
+	p.inbox.push(d)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.running {
+		return
+	}
+	p.running = true
+	go g.supervise(p)
+}
+
+// supervise runs a background plugin as long as it has pending messages.
+// If it exits before acknowledging all messages, it gets restarted and
+// receives the unacknowledged messages again.
+// After all messages are done, it keeps running until it exits by itself.
+//
+// A plugin which exits without receiving any message is restarted after
+// an increasing delay. After MaxDeliveryAttempts of such starts in a row,
+// all pending messages fail.
+func (g *GoPlug) supervise(p *plugin) {
+	maxAttempts := g.MaxDeliveryAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxDeliveryAttempts
+	}
+
+	reason := ReasonMessage
+	failedStarts := 0
+	for {
+		delivered := p.inbox.deliveredCount()
+		i, err := g.start(p, "", nil, reason)
+		reason = ReasonRestart
+		if err != nil {
+			p.inbox.fail(err)
+		} else {
+			err = i.wait()
+			if err == nil {
+				err = errors.New("plugin exited")
+			}
+			p.inbox.requeue(maxAttempts, err)
+
+			// Queued messages are not counted as attempts, so a plugin
+			// which never asks for them would be restarted forever.
+			if p.inbox.deliveredCount() == delivered {
+				failedStarts++
+			} else {
+				failedStarts = 0
+			}
+
+			if failedStarts >= maxAttempts {
+				p.inbox.fail(checkpoint.Wrap(fmt.Errorf("PluginID: %v: exited %v times without receiving a message: %v", p.ID, failedStarts, err), ErrHandlingMessage))
+				failedStarts = 0
+			}
+		}
+
+		p.mutex.Lock()
+		if !p.inbox.pending() {
+			p.running = false
+			p.mutex.Unlock()
+			return
+		}
+		p.mutex.Unlock()
+
+		if failedStarts > 0 {
+			select {
+			case <-time.After(g.restartBackoff(failedStarts)):
+			case <-g.shutdownChan():
+			}
+		}
+	}
+}
+
+// restartBackoff returns how long to wait before the next start of a
+// plugin which failed to start the given times in a row.
+func (g *GoPlug) restartBackoff(failedStarts int) time.Duration {
+	backoff := g.RestartBackoff
+	if backoff <= 0 {
+		backoff = DefaultRestartBackoff
+	}
+
+	for n := 1; n < failedStarts && backoff < maxRestartBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > maxRestartBackoff {
+		return maxRestartBackoff
+	}
+	return backoff
+}
+
+// EventResult contains the outcome of delivering an event to one plugin.
+type EventResult struct {
+	PluginID string
+
+	// Err is nil if the plugin acknowledged the event successfully.
+	Err error
+}
+
+// Publish sends an event to all Listener plugins which subscribed to the topic.
+// The payload is sent as json.
+//
+// It blocks until all plugins acknowledged the event or the context is done.
+// The result contains one entry for each subscribed plugin, sorted by the
+// plugin ID. If the context is done first, the remaining results contain
+// the context error, but the events still get delivered in the background.
+//
+// Events of the same topic are delivered to each plugin in the order they
+// were published. The delivery is at-least-once: If a plugin exits before
+// acknowledging an event, it is restarted and receives the event again
+// (up to MaxDeliveryAttempts). If it exits MaxDeliveryAttempts times in a
+// row without receiving any message, the event fails.
+func (g *GoPlug) Publish(ctx context.Context, topic string, payload interface{}) ([]EventResult, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
+
+	var subscribers []*plugin
+	g.listenerPluginsMutex.Lock()
+	for _, p := range g.listenerPlugins {
+		for _, event := range p.Events {
+			if event == topic {
+				subscribers = append(subscribers, p)
+				break
+			}
+		}
+	}
+	g.listenerPluginsMutex.Unlock()
+
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].ID < subscribers[j].ID
+	})
+
+	deliveries := make([]*delivery, len(subscribers))
+	for i, p := range subscribers {
+		deliveries[i] = newDelivery(EventMessage, topic, data)
+		g.deliver(p, deliveries[i])
+	}
+
+	results := make([]EventResult, len(subscribers))
+	for i, p := range subscribers {
+		results[i].PluginID = p.ID
+
+		select {
+		case res := <-deliveries[i].result:
+			results[i].Err = res.Err
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+		}
+	}
+
+	return results, nil
+}
+
+type NextRequest struct{}
+
+type NextResponse struct {
+	Message Message
+
+	// Closed is true if the plugin should stop asking for messages.
+	Closed bool
+}
+
+// Next blocks until a message for the plugin is available.
+func (h *HostControl) Next(args NextRequest, reply *NextResponse) error {
+	if h.plugin == nil || h.plugin.inbox == nil {
+		return checkpoint.From(ErrNoInbox)
+	}
+
+	d, ok := h.plugin.inbox.next(h.done)
+	if !ok {
+		*reply = NextResponse{
+			Closed: true,
+		}
+		return nil
+	}
+
+	*reply = NextResponse{
+		Message: d.Message,
+	}
+	return nil
+}
+
+type AckRequest struct {
+	ID uint64
+
+	// Result may contain a result of the message, depending on its kind.
+	Result json.RawMessage
+
+	// Error is set if the plugin failed to handle the message.
+	Error string
+}
+
+type AckResponse struct{}
+
+// Ack acknowledges a message received by Next.
+func (h *HostControl) Ack(args AckRequest, reply *AckResponse) error {
+	if h.plugin == nil || h.plugin.inbox == nil {
+		return checkpoint.From(ErrNoInbox)
+	}
+
+	res := ackResult{
+		Payload: args.Result,
+	}
+	if args.Error != "" {
+		res.Err = checkpoint.Wrap(fmt.Errorf("PluginID: %v: %v", h.plugin.ID, args.Error), ErrHandlingMessage)
+	}
+
+	h.plugin.countInvocation()
+	return h.plugin.inbox.ack(args.ID, res)
+}
+
+// Event is an event published by the host.
+type Event struct {
+	Topic   string
+	Payload json.RawMessage
+}
+
+// Decode unmarshals the payload of the event into v.
+func (e Event) Decode(v interface{}) error {
+	return checkpoint.From(json.Unmarshal(e.Payload, v))
+}
+
+// EventHandler handles an event.
+// If it returns an error, it is reported to the host.
+type EventHandler func(event Event) error
+
+// OnEvent registers the handler for the given topic and subscribes to it.
+// It has to be called before Init.
+func (c *Client) OnEvent(topic string, handler EventHandler) {
+	if c.eventHandlers == nil {
+		c.eventHandlers = make(map[string]EventHandler)
+	}
+
+	if _, ok := c.eventHandlers[topic]; !ok {
+		c.Events = append(c.Events, topic)
+	}
+	c.eventHandlers[topic] = handler
+}
+
+// Listen receives messages from the host and dispatches them to the
+// registered handlers. It blocks until the host stops the plugin or
+// closes the connection.
+//
+// Each message is handled in its own goroutine, so handlers for different
+// topics may run concurrently. Messages of the same topic are never
+// handled concurrently.
+func (c *Client) Listen() error {
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		res := NextResponse{}
+		call := c.client.Go("HostControl.Next", NextRequest{}, &res, nil)
+
+		var err error
+		select {
+		case <-call.Done:
+			err = call.Error
+		case <-c.stopped:
+			return nil
+		}
+
+		if isConnectionClosed(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if res.Closed {
+			return nil
+		}
+
+		wg.Add(1)
+		go func(m Message) {
+			defer wg.Done()
+
+			ack := AckRequest{
+				ID: m.ID,
+			}
+			result, err := c.dispatch(m)
+			if err != nil {
+				ack.Error = err.Error()
+			} else {
+				ack.Result = result
+			}
+
+			// If the ack fails, the connection is gone and the
+			// message gets delivered again.
+			_ = c.client.Call("HostControl.Ack", ack, &AckResponse{})
+		}(res.Message)
+	}
+}
+
+// isConnectionClosed checks if the error means that the host closed the
+// connection.
+func isConnectionClosed(err error) bool {
+	return errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// dispatch calls the handler registered for the message.
+// It returns the result which is sent back to the host.
+func (c *Client) dispatch(m Message) (json.RawMessage, error) {
+	switch m.Kind {
+	case EventMessage:
+		handler, ok := c.eventHandlers[m.Topic]
+		if !ok {
+			return nil, fmt.Errorf("event %v: %w", m.Topic, ErrNoHandler)
+		}
+
+		return nil, handler(Event{
+			Topic:   m.Topic,
+			Payload: m.Payload,
+		})
+	case FilterMessage:
+		return c.applyFilter(m)
+	case CallMessage:
+		return c.callService(m)
+	default:
+		return nil, fmt.Errorf("message kind %v: %w", m.Kind, ErrNoHandler)
+	}
+}
@@ -0,0 +1,124 @@
+package goplug
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testActions are the host actions used by the tests.
+type testActions struct{}
+
+func (testActions) Noop(args struct{}, reply *struct{}) error {
+	return nil
+}
+
+// startCountingHost counts the started plugin processes.
+type startCountingHost struct {
+	mutex  sync.Mutex
+	starts int
+}
+
+func (h *startCountingHost) RegisterOneShot(info PluginInfo, action OnOneShot) error {
+	return nil
+}
+
+func (h *startCountingHost) PluginStarted(info PluginInfo, reason Reason) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.starts++
+}
+
+func (h *startCountingHost) startCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.starts
+}
+
+// writeScript writes an executable shell script to the folder.
+func writeScript(t *testing.T, folder string, name string, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+
+	filePath := filepath.Join(folder, name)
+	err := ioutil.WriteFile(filePath, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestSuperviseListenerExitingImmediately(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{name: "exit", script: "exit 0"},
+		{name: "error", script: "exit 1"},
+		{name: "crash", script: "kill -9 $$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := &startCountingHost{}
+			g := &GoPlug{
+				Host:                host,
+				Actions:             testActions{},
+				MaxDeliveryAttempts: 3,
+				RestartBackoff:      time.Millisecond,
+			}
+			p := &plugin{
+				PluginInfo: PluginInfo{ID: "listener", PluginType: Listener},
+				filePath:   writeScript(t, t.TempDir(), "listener", tt.script),
+				inbox:      newInbox(),
+			}
+
+			first := newDelivery(EventMessage, "topic", []byte("1"))
+			second := newDelivery(EventMessage, "other", []byte("2"))
+			g.deliver(p, first)
+			g.deliver(p, second)
+
+			for _, d := range []*delivery{first, second} {
+				select {
+				case res := <-d.result:
+					if !errors.Is(res.Err, ErrHandlingMessage) {
+						t.Errorf("error = %v, want %v", res.Err, ErrHandlingMessage)
+					}
+				case <-time.After(10 * time.Second):
+					t.Fatal("the delivery did not fail")
+				}
+			}
+
+			if starts := host.startCount(); starts != 3 {
+				t.Errorf("starts = %v, want 3", starts)
+			}
+		})
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	g := &GoPlug{RestartBackoff: time.Second}
+
+	tests := []struct {
+		failedStarts int
+		want         time.Duration
+	}{
+		{failedStarts: 1, want: time.Second},
+		{failedStarts: 2, want: 2 * time.Second},
+		{failedStarts: 3, want: 4 * time.Second},
+		{failedStarts: 5, want: maxRestartBackoff},
+		{failedStarts: 100, want: maxRestartBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := g.restartBackoff(tt.failedStarts); got != tt.want {
+			t.Errorf("restartBackoff(%v) = %v, want %v", tt.failedStarts, got, tt.want)
+		}
+	}
+}
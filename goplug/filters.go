@@ -0,0 +1,202 @@
+// File filters.go contains filter chains. A filter is a hook for which any
+// amount of plugins can register. The host passes a value to the chain
+// and each plugin modifies it in turn.
+
+package goplug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aligator/checkpoint"
+)
+
+// DefaultFilterPriority is the priority which should be used by filters
+// which do not care about their order.
+const DefaultFilterPriority = 10
+
+// FilterInfo registers a plugin for a filter hook.
+type FilterInfo struct {
+	Hook string `json:"hook"`
+
+	// Priority defines the order in which the filters of a hook are applied.
+	// Filters with a lower priority are applied first.
+	// Filters with the same priority are applied ordered by the plugin ID.
+	Priority int `json:"priority"`
+}
+
+// FilterErrorPolicy defines what happens if a plugin in a filter chain fails.
+type FilterErrorPolicy int
+
+const (
+	// AbortOnFilterError stops the chain at the first failing plugin.
+	// ApplyFilters returns the error and does not change the value.
+	AbortOnFilterError FilterErrorPolicy = iota
+
+	// SkipFailedFilter logs the error and continues the chain with the value
+	// from before the failing plugin.
+	SkipFailedFilter
+)
+
+// filterEntry is a registered filter of a plugin.
+type filterEntry struct {
+	plugin   *plugin
+	priority int
+}
+
+// registerFilters adds all filters of the plugin to the filter chains.
+// If the host refuses any of them, none of the filters get added.
+func (g *GoPlug) registerFilters(p *plugin) error {
+	if filterHost, ok := g.Host.(FilterHost); ok {
+		for _, filter := range p.Filters {
+			err := filterHost.RegisterFilter(p.PluginInfo, filter)
+			if err != nil {
+				return checkpoint.From(fmt.Errorf("PluginID: %v: filter %v: %w", p.ID, filter.Hook, err))
+			}
+		}
+	}
+
+	g.filtersMutex.Lock()
+	defer g.filtersMutex.Unlock()
+
+	for _, filter := range p.Filters {
+		chain := append(g.filters[filter.Hook], filterEntry{
+			plugin:   p,
+			priority: filter.Priority,
+		})
+
+		sort.SliceStable(chain, func(i, j int) bool {
+			if chain[i].priority != chain[j].priority {
+				return chain[i].priority < chain[j].priority
+			}
+			return chain[i].plugin.ID < chain[j].plugin.ID
+		})
+		g.filters[filter.Hook] = chain
+	}
+
+	return nil
+}
+
+// unregisterFilters removes all filters of the plugin from the filter chains.
+func (g *GoPlug) unregisterFilters(p *plugin) {
+	g.filtersMutex.Lock()
+	defer g.filtersMutex.Unlock()
+
+	for _, filter := range p.Filters {
+		var chain []filterEntry
+		for _, entry := range g.filters[filter.Hook] {
+			if entry.plugin != p {
+				chain = append(chain, entry)
+			}
+		}
+		g.filters[filter.Hook] = chain
+	}
+}
+
+// ApplyFilters passes the value through all plugins which registered a
+// filter for the hook. Each plugin receives the value returned by the
+// previous one. The order is defined by the priorities of the filters.
+//
+// value has to be a pointer. It is sent as json and the result of the
+// last plugin is unmarshalled back into it.
+//
+// If a plugin fails, the FilterErrorPolicy of GoPlug is applied.
+func (g *GoPlug) ApplyFilters(ctx context.Context, hook string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	g.filtersMutex.Lock()
+	chain := make([]filterEntry, len(g.filters[hook]))
+	copy(chain, g.filters[hook])
+	g.filtersMutex.Unlock()
+
+	if len(chain) == 0 {
+		return nil
+	}
+
+	for _, entry := range chain {
+		d := newDelivery(FilterMessage, hook, data)
+		g.deliver(entry.plugin, d)
+
+		var res ackResult
+		select {
+		case res = <-d.result:
+		case <-ctx.Done():
+			return checkpoint.From(ctx.Err())
+		}
+
+		if res.Err != nil {
+			if g.FilterErrorPolicy == SkipFailedFilter {
+				log.Println(entry.plugin.ID, "- filter", hook, "failed:", res.Err)
+				continue
+			}
+
+			return checkpoint.From(fmt.Errorf("filter %v: %w", hook, res.Err))
+		}
+
+		data = res.Payload
+	}
+
+	return checkpoint.From(json.Unmarshal(data, value))
+}
+
+// FilterValue is a value passed to a filter of a plugin.
+type FilterValue struct {
+	Hook  string
+	Value json.RawMessage
+}
+
+// Decode unmarshals the value into v.
+func (f FilterValue) Decode(v interface{}) error {
+	return checkpoint.From(json.Unmarshal(f.Value, v))
+}
+
+// FilterHandler modifies a value passed through a filter chain.
+// The returned value is sent back to the host as json.
+type FilterHandler func(value FilterValue) (interface{}, error)
+
+// OnFilter registers the handler for the given hook.
+// It has to be called before Init.
+func (c *Client) OnFilter(hook string, priority int, handler FilterHandler) {
+	if c.filterHandlers == nil {
+		c.filterHandlers = make(map[string]FilterHandler)
+	}
+
+	c.filterHandlers[hook] = handler
+
+	for i := range c.Filters {
+		if c.Filters[i].Hook == hook {
+			c.Filters[i].Priority = priority
+			return
+		}
+	}
+
+	c.Filters = append(c.Filters, FilterInfo{
+		Hook:     hook,
+		Priority: priority,
+	})
+}
+
+// applyFilter calls the filter handler registered for the message.
+func (c *Client) applyFilter(m Message) (json.RawMessage, error) {
+	handler, ok := c.filterHandlers[m.Topic]
+	if !ok {
+		return nil, fmt.Errorf("filter %v: %w", m.Topic, ErrNoHandler)
+	}
+
+	res, err := handler(FilterValue{
+		Hook:  m.Topic,
+		Value: m.Payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(res)
+	return data, checkpoint.From(err)
+}
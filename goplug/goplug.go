@@ -1,21 +1,24 @@
 package goplug
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
 	"os/exec"
 	"path"
+	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/aligator/checkpoint"
-	"github.com/aligator/goplug/common"
 	"github.com/aligator/goplug/errutil"
 )
 
@@ -26,8 +29,12 @@ type PluginType string
 var (
 	ErrPluginDoesNotExist = errors.New("plugin does not exist")
 	ErrCallingPlugin      = errors.New("could not call the plugin")
+	ErrDiscoveryTimeout   = errors.New("plugin discovery timed out")
 )
 
+// DefaultDiscoveryTimeout is used if GoPlug.DiscoveryTimeout is not set.
+const DefaultDiscoveryTimeout = 10 * time.Second
+
 const (
 	// OneShot is a plugin which gets called when a specific event happens.
 	// This can be for example a subcommand in command line tools.
@@ -39,6 +46,11 @@ const (
 	// This type of plugin gets queried by the host and runs in the background
 	// as long as the host needs it.
 	DataSource = PluginType("data_source")
+
+	// Listener is a plugin which subscribes to events published by the host.
+	// This type of plugin gets started as soon as the first event is
+	// published to it and runs in the background.
+	Listener = PluginType("listener")
 )
 
 // PluginInfo contains all basic information about a plugin.
@@ -46,6 +58,8 @@ type PluginInfo struct {
 	// ID should be unique across all plugins.
 	// It is good practice to use a domain, email or github repo
 	// which belongs to you.
+	// It may contain segments of letters, digits and "._~@+-" separated
+	// by "/". See ValidateID.
 	//  e.g.
 	//  * github.com/aligator/superplugin
 	//  * my@email.com/superplugin
@@ -54,18 +68,145 @@ type PluginInfo struct {
 
 	PluginType PluginType `json:"plugin_type"`
 
+	// Name is a human readable name of the plugin.
+	Name string `json:"name,omitempty"`
+
+	// Description is a short description of the plugin.
+	Description string `json:"description,omitempty"`
+
+	// Authors contains the authors of the plugin,
+	// e.g. "Name <name@example.com>".
+	Authors []string `json:"authors,omitempty"`
+
+	// Homepage is an http(s) url of the plugin.
+	Homepage string `json:"homepage,omitempty"`
+
+	// License is the license of the plugin, preferably as SPDX identifier.
+	License string `json:"license,omitempty"`
+
+	// Tags are keywords for the plugin. They may not contain whitespace.
+	Tags []string `json:"tags,omitempty"`
+
+	// MinGoPlugVersion is the minimum version of goplug used by the host.
+	// The plugin is incompatible with older hosts.
+	MinGoPlugVersion string `json:"min_goplug_version,omitempty"`
+
 	// Metadata is a field which can be used by the host to allow custom
 	// plugin information. It is subject to the host to provide ways for the
 	// plugin to read and set it properly.
-	// It may be in any text form (such as json) and is just
-	// sent as a normal string.
-	Metadata string `json:"metadata"`
+	// It is sent as raw json. Use EncodeMetadata and DecodeMetadata
+	// or the typed accessors generated for a "//goplug:metadata" struct.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+
+	// EntryPoints contains the named entry points of a OneShot plugin,
+	// e.g. one for each subcommand. If it is set, each entry point is
+	// registered at the host instead of the plugin itself.
+	EntryPoints []EntryPoint `json:"entry_points,omitempty"`
+
+	// Completion is true if the plugin provides shell completions.
+	// It is set by Client.OnComplete.
+	Completion bool `json:"completion,omitempty"`
+
+	// Interactive is true if a OneShot plugin needs the terminal of the
+	// host, e.g. to prompt the user. Its stdin, stdout and stderr are then
+	// connected to the terminal and the rpc uses additional pipes.
+	// Only one interactive plugin runs at a time.
+	Interactive bool `json:"interactive,omitempty"`
+
+	// Events contains all events a Listener plugin subscribes to.
+	Events []string `json:"events,omitempty"`
+
+	// Filters contains all filter hooks a Listener plugin registers for.
+	Filters []FilterInfo `json:"filters,omitempty"`
+
+	// Services contains all services a Listener plugin exposes to other
+	// plugins.
+	Services []string `json:"services,omitempty"`
+
+	// ConfigSchema is a JSON Schema for the config of the plugin.
+	// If it is set, the config passed by the host is validated against it.
+	ConfigSchema json.RawMessage `json:"config_schema,omitempty"`
+
+	// Version is the semver of the plugin itself.
+	Version string `json:"version,omitempty"`
+
+	// HostAPI is the range of host API versions the plugin is compatible
+	// with, e.g. ">=1.2.0 <3.0.0". See VersionMatches for the syntax.
+	// If it is empty, all host API versions with the same major version
+	// as HostAPIVersion, starting from HostAPIVersion, are accepted.
+	HostAPI string `json:"host_api,omitempty"`
+
+	// Dependencies contains all plugins required by this plugin.
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+
+	// HostAPIVersion and ActionsHash describe the host API the plugin was
+	// built against. They are set by the generated actions.
+	HostAPIVersion string `json:"host_api_version,omitempty"`
+	ActionsHash    string `json:"actions_hash,omitempty"`
+}
+
+// EncodeMetadata sets the Metadata to v encoded as json.
+func (i *PluginInfo) EncodeMetadata(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	i.Metadata = data
+	return nil
+}
+
+// DecodeMetadata unmarshals the Metadata into v.
+// If no Metadata is set, v is not changed.
+func (i PluginInfo) DecodeMetadata(v interface{}) error {
+	if len(i.Metadata) == 0 {
+		return nil
+	}
+
+	return checkpoint.From(json.Unmarshal(i.Metadata, v))
 }
 
 // plugin is the internal representation of a plugin.
 type plugin struct {
 	PluginInfo
 	filePath string
+
+	// inbox contains the messages sent to a background plugin.
+	inbox *inbox
+
+	// mutex guards running, instances and the statistics.
+	mutex sync.Mutex
+
+	// running is true while a background plugin is supervised.
+	running bool
+
+	// instances contains all running processes of the plugin.
+	instances map[*instance]struct{}
+
+	// lazy is true if the plugin information was read from a manifest or
+	// the cache. The plugin gets validated by activate on its first use.
+	lazy         bool
+	activateOnce sync.Once
+	activateErr  error
+
+	// Statistics returned by GoPlug.Plugins.
+	lastErr     error
+	crashed     bool
+	startedAt   time.Time
+	starts      int
+	invocations int
+}
+
+// runningInstances returns all running processes of the plugin.
+func (p *plugin) runningInstances() []*instance {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	instances := make([]*instance, 0, len(p.instances))
+	for i := range p.instances {
+		instances = append(instances, i)
+	}
+	return instances
 }
 
 // GoPlug is the main struct used to initialize and load plugins.
@@ -103,20 +244,163 @@ type GoPlug struct {
 
 	// oneShotPlugins contains all plugins which registered themselves as
 	// oneShot plugins.
-	// When in the initialization phase, use the oneShotPluginsMutex to
-	// lock it.
-	// After initialization phase do not write to the map anymore.
+	// Use the oneShotPluginsMutex to lock it, as it may be changed by Watch.
 	oneShotPlugins map[string]*plugin
 
 	// oneShotPluginsMutex is a mutex which locks the
-	// oneShotPlugins map while in the initialization phase
-	// to prevent concurrent writes to it.
+	// oneShotPlugins map to prevent concurrent access to it.
 	oneShotPluginsMutex sync.Mutex
+
+	// listenerPlugins contains all plugins which registered themselves as
+	// Listener plugins.
+	listenerPlugins map[string]*plugin
+
+	// listenerPluginsMutex locks the listenerPlugins map.
+	listenerPluginsMutex sync.Mutex
+
+	// MaxDeliveryAttempts defines how often a message is sent to a
+	// background plugin until it gets acknowledged.
+	// If it is not set, DefaultMaxDeliveryAttempts is used.
+	MaxDeliveryAttempts int
+
+	// RestartBackoff is the time to wait before restarting a background
+	// plugin which exited without receiving a message. It doubles with
+	// each further start. If it is not set, DefaultRestartBackoff is used.
+	RestartBackoff time.Duration
+
+	// FilterErrorPolicy defines what happens if a plugin of a filter chain
+	// fails. By default, the chain is aborted.
+	FilterErrorPolicy FilterErrorPolicy
+
+	// filters contains the filter chains for all hooks, ordered by
+	// their priority.
+	filters map[string][]filterEntry
+
+	// filtersMutex locks the filters map.
+	filtersMutex sync.Mutex
+
+	// CallPolicy is checked for each call from one plugin to the service
+	// of another plugin. If it is nil, all exposed services can be called
+	// by all plugins.
+	CallPolicy CallPolicy
+
+	// CallTimeout defines how long a plugin waits for the reply when it
+	// calls the service of another plugin. If the target does not reply
+	// in time, the call fails with ErrCallTimeout.
+	// If it is not set, DefaultCallTimeout is used.
+	CallTimeout time.Duration
+
+	// Storage persists the key/value storage of the plugins.
+	// If it is nil, a FileStorage is used which saves the files into
+	// the DefaultStorageFolder inside of the PluginFolder.
+	Storage Storage
+
+	// storageOnce is used to create the default storage.
+	storageOnce sync.Once
+
+	// ConfigFile is a json or yaml file which contains the config
+	// of each plugin keyed by its ID.
+	// Each plugin receives its own config when it gets started.
+	ConfigFile string
+
+	// configs contains the config of each plugin as json.
+	configs map[string]json.RawMessage
+
+	// MaxParallelism limits how many plugins are discovered at the same
+	// time. If it is not set, the number of CPUs is used.
+	MaxParallelism int
+
+	// DiscoveryTimeout defines how long a plugin may take to return its
+	// information. Plugins which take longer get killed and are reported
+	// with ErrDiscoveryTimeout.
+	// If it is not set, DefaultDiscoveryTimeout is used.
+	DiscoveryTimeout time.Duration
+
+	// Lazy lets Init read the plugin information from manifests or the
+	// CacheFile instead of starting each plugin. Only plugins which have
+	// neither are started in discovery mode.
+	// The plugins are validated when they are used for the first time, so
+	// errors like an incompatible host API or an invalid config are
+	// returned when the plugin is called instead of by Init.
+	Lazy bool
+
+	// CacheFile is the file which caches the plugin information in Lazy
+	// mode. If it is not set, DefaultCacheFile inside of the PluginFolder
+	// is used.
+	CacheFile string
+
+	// cache contains the cached plugin information keyed by the path of
+	// the plugin binaries.
+	cache      map[string]cacheEntry
+	cacheMutex sync.Mutex
+
+	// registerMutex serializes the calls to the registration methods of
+	// the Host done by Init and Watch.
+	registerMutex sync.Mutex
+
+	// waiting contains the plugins whose dependencies are not satisfied,
+	// keyed by their path. Watch registers them as soon as their
+	// dependencies are available. It is guarded by the registerMutex.
+	waiting map[string]*plugin
+
+	// ForwardSignals contains the signals which are forwarded to a
+	// OneShot plugin while it runs. If it is nil, DefaultForwardSignals
+	// is used. Set it to an empty slice to disable the forwarding.
+	ForwardSignals []os.Signal
+
+	// SignalGracePeriod defines how long a OneShot plugin may take to
+	// exit after a signal was forwarded to it before it gets killed.
+	// If it is not set, DefaultSignalGracePeriod is used.
+	SignalGracePeriod time.Duration
+
+	// CompletionTimeout defines how long a plugin may take to return its
+	// completions when using a Completer.
+	// If it is not set, DefaultCompletionTimeout is used.
+	CompletionTimeout time.Duration
+
+	// Strict lets Init fail if any plugin could not be loaded.
+	// By default, Init loads all plugins which work and only reports
+	// the others in the InitReport.
+	Strict bool
+
+	// CompatibilityPolicy defines what happens with plugins which are not
+	// compatible with the host API. By default, they are refused.
+	CompatibilityPolicy CompatibilityPolicy
+
+	// WatchInterval defines how often Watch checks the PluginFolder for
+	// changes. If it is not set, DefaultWatchInterval is used.
+	WatchInterval time.Duration
+
+	// files contains the state of all plugin files found by the last scan
+	// of the PluginFolder.
+	files map[string]fileState
+
+	// filesMutex locks the files map.
+	filesMutex sync.Mutex
+
+	// inactive contains all plugin files which could not be registered,
+	// keyed by their path.
+	inactive      map[string]PluginStatus
+	inactiveMutex sync.Mutex
+
+	// interactiveMutex serializes the invocations of interactive plugins,
+	// as they share the terminal of the host.
+	interactiveMutex sync.Mutex
+
+	// running contains all running plugin processes, including those of
+	// unloaded or replaced plugins.
+	running      map[*instance]*plugin
+	runningMutex sync.Mutex
+
+	// shutdownCh gets closed by Shutdown.
+	// Use shutdownChan to access it.
+	shutdownCh    chan struct{}
+	shutdownMutex sync.Mutex
 }
 
 // Checks if the plugin is a valid executable.
 // These checks are done without executing it.
-func isValidPlugin(info fs.FileInfo) bool {
+func (g *GoPlug) isValidPlugin(info fs.FileInfo) bool {
 	if info.IsDir() {
 		return false
 	}
@@ -125,6 +409,10 @@ func isValidPlugin(info fs.FileInfo) bool {
 		return false
 	}
 
+	if !g.isPluginFile(info.Name()) {
+		return false
+	}
+
 	// ToDo: implement checks
 	//       Maybe invent a custom filename rule, such as
 	//       "***.plugin" ("***.plugin.exe" on windows).
@@ -134,18 +422,57 @@ func isValidPlugin(info fs.FileInfo) bool {
 
 // Init initializes and starts all plugins.
 // It blocks until all plugins are initialized.
-func (g *GoPlug) Init() error {
+// The plugins are discovered concurrently. Afterwards they are registered
+// at the Host one by one, ordered by their ID but after all plugins they
+// depend on.
+//
+// Plugins which cannot be loaded, e.g. because they crash or their
+// dependencies are not satisfied, do not stop the other plugins from being
+// loaded. They are listed in the returned InitReport. Only if the plugins
+// cannot be searched at all or the ConfigFile cannot be loaded, an error
+// is returned. If Strict is set, an errutil.ErrorList with a *PluginFailure
+// for each failed plugin is returned additionally.
+func (g *GoPlug) Init() (*InitReport, error) {
 	entries, err := ioutil.ReadDir(g.PluginFolder)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = g.loadConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	g.oneShotPluginsMutex.Lock()
 	g.oneShotPlugins = make(map[string]*plugin)
 	g.oneShotPluginsMutex.Unlock()
 
-	errCh := make(chan error)
-	allErrorsCh := errutil.Collect(errCh)
+	g.listenerPluginsMutex.Lock()
+	g.listenerPlugins = make(map[string]*plugin)
+	g.listenerPluginsMutex.Unlock()
+
+	g.filtersMutex.Lock()
+	g.filters = make(map[string][]filterEntry)
+	g.filtersMutex.Unlock()
+
+	g.inactiveMutex.Lock()
+	g.inactive = make(map[string]PluginStatus)
+	g.inactiveMutex.Unlock()
+
+	g.loadCache()
+
+	report := &InitReport{}
+
+	// discovered contains all plugins which returned valid information.
+	var discovered []*plugin
+	discoveredMutex := sync.Mutex{}
+
+	maxParallelism := g.MaxParallelism
+	if maxParallelism <= 0 {
+		maxParallelism = runtime.NumCPU()
+	}
+	// slots limits the number of plugins discovered at the same time.
+	slots := make(chan struct{}, maxParallelism)
 
 	wg := sync.WaitGroup{}
 	wg.Add(len(entries))
@@ -155,121 +482,303 @@ func (g *GoPlug) Init() error {
 		go func() {
 			defer wg.Done()
 
-			if !isValidPlugin(entry) {
+			if !g.isValidPlugin(entry) {
 				return
 			}
 
-			// Start the plugin with the -init flag.
-			// The plugin should return some information about it.
-			filePath := path.Join(g.PluginFolder, entry.Name())
+			slots <- struct{}{}
+			defer func() { <-slots }()
 
-			p := plugin{
-				filePath: filePath,
-			}
-
-			// Call the plugin with -init which should return the
-			// plugin information as json to stdout.
-			cmd := exec.Command(filePath, "-init")
-			// Connect stderr to be able to get errors and panics
-			// from the plugin.
-			cmd.Stderr = os.Stderr
-			res, err := cmd.Output()
-			if err != nil {
-				errCh <- checkpoint.From(err)
-				return
-			}
-
-			err = json.Unmarshal(res, &p.PluginInfo)
-			if err != nil {
-				errCh <- checkpoint.From(err)
-				return
-			}
-
-			if p.PluginType != OneShot {
-				log.Println(p.ID, "- currently only one_shot plugins are supported")
-				return
-			}
+			filePath := path.Join(g.PluginFolder, entry.Name())
+			p, err := g.load(filePath)
 
-			// Register the plugin as OneShot.
-			g.oneShotPluginsMutex.Lock()
-			g.oneShotPlugins[p.ID] = &p
-			g.oneShotPluginsMutex.Unlock()
-
-			// Call the implementation from the host.
-			// The callback should be called when the plugin gets called.
-			// All arguments it should run with are passed by the slice.
-			err = g.Host.RegisterOneShot(p.PluginInfo, func(args []string) error {
-				// Actually start the plugin in onShot mode.
-				return g.oneShot(p.ID, args)
-			})
+			discoveredMutex.Lock()
+			defer discoveredMutex.Unlock()
 			if err != nil {
-				errCh <- checkpoint.From(err)
+				report.Failed = append(report.Failed, g.setDiscoveryFailed(filePath, p, err))
 				return
 			}
+			discovered = append(discovered, p)
 		}()
 	}
 
 	wg.Wait()
-	close(errCh)
 
-	err, _ = <-allErrorsCh
-	return err
-}
+	// Remember the state of the files to detect changes in Watch.
+	g.filesMutex.Lock()
+	g.files = g.snapshotFiles(entries)
+	g.filesMutex.Unlock()
 
-// oneShot starts the plugin as oneShot plugin with the given arguments.
-func (g *GoPlug) oneShot(ID string, args []string) error {
-	if p, ok := g.oneShotPlugins[ID]; !ok {
-		return checkpoint.From(fmt.Errorf("PluginID: %v: %w", ID, ErrPluginDoesNotExist))
-	} else {
-		// Start rpc.
-		cmd := exec.Command(p.filePath, args...)
+	err = g.saveCache()
+	if err != nil {
+		log.Println("could not save the plugin cache:", err)
+	}
 
-		// Connect stdin and stdout
-		outPipe, err := cmd.StdoutPipe()
+	// Register the plugins one by one after their dependencies.
+	g.registerMutex.Lock()
+	defer g.registerMutex.Unlock()
+
+	// If several plugins use the same ID, the first one by path is used.
+	sort.Slice(discovered, func(i, j int) bool {
+		return discovered[i].filePath < discovered[j].filePath
+	})
+	discovered, errs := duplicateIDs(discovered)
+	for _, e := range errs {
+		failure := newFailure(e.plugin.filePath, e.plugin, PhaseValidation, e.err)
+		g.setInactive(e.plugin.filePath, e.plugin, PluginDisabled, failure)
+		report.Failed = append(report.Failed, failure)
+	}
+
+	sorted, errs := sortByDependencies(discovered)
+	for _, e := range errs {
+		g.setWaiting(e.plugin, e.err)
+		report.Failed = append(report.Failed, newFailure(e.plugin.filePath, e.plugin, PhaseDependencies, e.err))
+	}
+
+	for _, p := range sorted {
+		err := g.register(p)
 		if err != nil {
-			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+			failure := newFailure(p.filePath, p, PhaseRegistration, err)
+			g.setInactive(p.filePath, p, PluginDisabled, failure)
+			if errors.Is(err, ErrUnsupportedPluginType) {
+				log.Println(err)
+				report.Skipped = append(report.Skipped, failure)
+			} else {
+				report.Failed = append(report.Failed, failure)
+			}
+			continue
 		}
 
-		inPipe, err := cmd.StdinPipe()
-		if err != nil {
-			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+		report.Loaded = append(report.Loaded, p.status())
+	}
+
+	report.sortFailures()
+
+	if g.Strict && len(report.Failed) > 0 {
+		var errs errutil.ErrorList
+		for _, failure := range report.Failed {
+			errs = append(errs, failure)
 		}
+		return report, errs
+	}
+
+	return report, nil
+}
 
-		// Connect stderr directly to the host stderr to
-		// still allow to receive panics and errors of the plugin.
-		cmd.Stderr = os.Stderr
+// discover starts the plugin in discovery mode to get the information
+// about it and checks if it can be used with this host.
+// If the information could be read but the plugin cannot be used, the
+// plugin is returned together with the error.
+// All errors are returned as *PluginFailure.
+func (g *GoPlug) discover(filePath string) (*plugin, error) {
+	p, err := g.probe(filePath)
+	if err != nil {
+		return p, err
+	}
 
-		// Use a CombinedReadWriter which combines the two pipes.
-		codec := jsonrpc.NewServerCodec(common.CombinedReadWriter{
-			In:  outPipe,
-			Out: inPipe,
-		})
+	return p, g.validate(p)
+}
 
-		s := rpc.NewServer()
+// probe starts the plugin in discovery mode and returns the information
+// about it. Only the information itself is validated.
+func (g *GoPlug) probe(filePath string) (*plugin, error) {
+	p := plugin{
+		filePath: filePath,
+	}
 
-		// Register the host specific actions.
-		err = s.RegisterName("Host", g.Actions)
-		if err != nil {
-			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
-		}
+	timeout := g.DiscoveryTimeout
+	if timeout <= 0 {
+		timeout = DefaultDiscoveryTimeout
+	}
+	// The plugin gets killed if it does not finish in time.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Start the plugin in discovery mode which should return the
+	// plugin information as json to stdout.
+	cmd := exec.CommandContext(ctx, filePath)
+	cmd.Env = append(os.Environ(), modeEnv+"="+discoverMode)
+	setProcessAttributes(cmd, false)
+	// Connect stderr to be able to get errors and panics
+	// from the plugin.
+	cmd.Stderr = os.Stderr
+	res, err := output(ctx, cmd)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, newFailure(filePath, nil, PhaseExec, checkpoint.Wrap(fmt.Errorf("killed after %v", timeout), ErrDiscoveryTimeout))
+	} else if err != nil {
+		return nil, newFailure(filePath, nil, PhaseExec, checkpoint.From(err))
+	}
 
-		// Register actions available to all plugins.
-		err = s.RegisterName("HostControl", &HostControl{})
-		if err != nil {
-			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
-		}
+	err = json.Unmarshal(res, &p.PluginInfo)
+	if err != nil {
+		return nil, newFailure(filePath, nil, PhaseDecode, checkpoint.From(err))
+	}
 
-		// Start the jsonrpc server.
-		go func() {
-			s.ServeCodec(codec)
-		}()
+	err = validateInfo(p.PluginInfo)
+	if err != nil {
+		return &p, newFailure(filePath, &p, PhaseValidation, err)
+	}
+
+	return &p, nil
+}
+
+// validate checks if the plugin can be used with this host.
+// The errors are returned as *PluginFailure.
+func (g *GoPlug) validate(p *plugin) error {
+	// Only Listener plugins run in the background to apply filters.
+	if len(p.Filters) > 0 && p.PluginType != Listener {
+		err := checkpoint.Wrap(fmt.Errorf("PluginID: %v: %v plugins cannot register filters", p.ID, p.PluginType), ErrInvalidPluginInfo)
+		return newFailure(p.filePath, p, PhaseValidation, err)
+	}
+
+	// Do not register plugins which are built against an
+	// incompatible host API.
+	err := g.checkCompatibility(p)
+	if err != nil {
+		return newFailure(p.filePath, p, PhaseCompatibility, err)
+	}
+
+	// Do not register plugins with an invalid config.
+	err = g.validateConfig(p)
+	if err != nil {
+		return newFailure(p.filePath, p, PhaseConfig, err)
+	}
+
+	return nil
+}
+
+// output runs the command and returns its stdout.
+// In contrast to exec.Cmd.Output, it does not wait for processes started by
+// the command which still hold stdout open after the context is done.
+func output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
+	defer r.Close()
+
+	cmd.Stdout = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
+
+	var stdout bytes.Buffer
+	readCh := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(&stdout, r)
+		readCh <- err
+	}()
+
+	err = cmd.Wait()
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
 
-		// Start the plugin.
-		err = cmd.Run()
+	select {
+	case err := <-readCh:
+		return stdout.Bytes(), checkpoint.From(err)
+	case <-ctx.Done():
+		return nil, checkpoint.From(ctx.Err())
+	}
+}
+
+// setDiscoveryFailed remembers the error of a plugin returned by discover
+// and returns it as PluginFailure.
+func (g *GoPlug) setDiscoveryFailed(filePath string, p *plugin, err error) *PluginFailure {
+	var failure *PluginFailure
+	if !errors.As(err, &failure) {
+		failure = newFailure(filePath, p, PhaseExec, err)
+	}
+
+	if p == nil {
+		g.setInactive(filePath, nil, PluginFailed, failure)
+	} else {
+		g.setInactive(filePath, p, PluginDisabled, failure)
+	}
+	return failure
+}
+
+// register adds the plugin to the plugins of its type and registers it
+// at the host.
+func (g *GoPlug) register(p *plugin) error {
+	if p.PluginType == Listener {
+		// Register the plugin as Listener.
+		// It gets started as soon as an event is published to it.
+		p.inbox = newInbox()
+		err := g.registerFilters(p)
 		if err != nil {
-			return err
+			return checkpoint.From(err)
 		}
 
+		g.listenerPluginsMutex.Lock()
+		g.listenerPlugins[p.ID] = p
+		g.listenerPluginsMutex.Unlock()
 		return nil
 	}
+
+	if p.PluginType != OneShot {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %v: currently only one_shot and listener plugins are supported: %w", p.ID, p.PluginType, ErrUnsupportedPluginType))
+	}
+
+	var err error
+	if len(p.EntryPoints) > 0 {
+		err = g.registerEntryPoints(p)
+	} else {
+		// Call the implementation from the host.
+		// The callback should be called when the plugin gets called.
+		// All arguments it should run with are passed by the slice.
+		err = g.Host.RegisterOneShot(p.PluginInfo, g.oneShotAction(p.ID))
+	}
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	// Register the plugin as OneShot.
+	// The actions look it up by its ID, so it can be added after the host
+	// accepted it.
+	g.oneShotPluginsMutex.Lock()
+	g.oneShotPlugins[p.ID] = p
+	g.oneShotPluginsMutex.Unlock()
+	return nil
+}
+
+// oneShotAction returns the action which is passed to the host to start
+// the OneShot plugin.
+func (g *GoPlug) oneShotAction(ID string) OnOneShot {
+	return func(args []string) error {
+		// Actually start the plugin in onShot mode.
+		return g.oneShot(ID, "", args)
+	}
+}
+
+// oneShot starts the plugin as oneShot plugin with the given arguments.
+// The entryPoint is empty if the plugin itself is invoked.
+func (g *GoPlug) oneShot(ID string, entryPoint string, args []string) error {
+	g.oneShotPluginsMutex.Lock()
+	p, ok := g.oneShotPlugins[ID]
+	g.oneShotPluginsMutex.Unlock()
+	if !ok {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %w", ID, ErrPluginDoesNotExist))
+	}
+
+	// The entry point may not exist anymore if the plugin was replaced.
+	if entryPoint != "" && !p.hasEntryPoint(entryPoint) {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %v: %w", ID, entryPoint, ErrEntryPointDoesNotExist))
+	}
+
+	// Only one plugin at a time can use the terminal.
+	if p.isInteractive() {
+		g.interactiveMutex.Lock()
+		defer g.interactiveMutex.Unlock()
+	}
+
+	p.countInvocation()
+	i, err := g.start(p, entryPoint, args, ReasonInvoked)
+	if err != nil {
+		return err
+	}
+
+	return g.waitForwardingSignals(p, i)
 }
@@ -3,6 +3,16 @@ package goplug
 type OnOneShot func(args []string) error
 
 // Host has to be implemented and passed to GoPlug by the host application.
+//
+// The registration methods of Host, FilterHost and ReloadHost are never
+// called concurrently. GoPlug.Init calls them from its own goroutine after
+// all plugins are discovered, ordered by the plugin IDs. GoPlug.Watch calls
+// them from its goroutine. So they need no synchronization as long as the
+// registered values are not accessed by other goroutines while Init or
+// Watch runs.
+//
+// The lifecycle notifications like StartedHost may be called concurrently
+// from any goroutine.
 type Host interface {
 	// RegisterOneShot will be called if a plugin provides OneShot functionality.
 	// The action should be used to "start" a OneShot plugin.
@@ -11,3 +21,45 @@ type Host interface {
 	// When this subcommand gets called, the action has to be executed.
 	RegisterOneShot(info PluginInfo, action OnOneShot) error
 }
+
+// FilterHost can be implemented additionally to Host.
+// If it is implemented, it gets notified for each filter a plugin registers.
+type FilterHost interface {
+	// RegisterFilter will be called for each filter hook a plugin registers for.
+	// If it returns an error, none of the filters of the plugin get
+	// registered and the plugin fails to load. The filters which were
+	// accepted before are not used.
+	RegisterFilter(info PluginInfo, filter FilterInfo) error
+}
+
+// EntryPointHost can be implemented additionally to Host.
+// If it is implemented, it gets called for each entry point of a OneShot
+// plugin instead of Host.RegisterOneShot.
+// If it is not implemented, Host.RegisterOneShot is called for each entry
+// point with the Metadata of the entry point as Metadata of the plugin.
+type EntryPointHost interface {
+	// RegisterEntryPoint will be called for each entry point of a plugin.
+	// The action invokes the plugin through this entry point.
+	RegisterEntryPoint(info PluginInfo, entry EntryPoint, action OnOneShot) error
+}
+
+// ReloadHost can be implemented additionally to Host.
+// If it is implemented, it gets notified when GoPlug.Watch detects that a
+// OneShot plugin was removed or replaced.
+// If it is not implemented, replaced plugins are registered again by
+// Host.RegisterOneShot and the actions of removed plugins return
+// ErrPluginDoesNotExist.
+type ReloadHost interface {
+	// UnregisterOneShot will be called if a OneShot plugin was removed.
+	// Its action should not be used anymore. For plugins with entry
+	// points, the actions of all entry points should not be used anymore.
+	UnregisterOneShot(info PluginInfo) error
+
+	// ReregisterOneShot will be called if the binary of a OneShot plugin
+	// was replaced. old contains the information before the change.
+	// The action replaces the action of the old plugin.
+	// If the old or the new plugin has entry points, UnregisterOneShot is
+	// called for the old plugin instead and the entry points of the new
+	// plugin are registered again.
+	ReregisterOneShot(old PluginInfo, info PluginInfo, action OnOneShot) error
+}
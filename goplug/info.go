@@ -0,0 +1,113 @@
+// File info.go contains the validation of the plugin information.
+
+package goplug
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrInvalidPluginInfo = errors.New("invalid plugin information")
+	ErrDuplicatePluginID = errors.New("plugin id is used by another plugin")
+)
+
+// MaxIDLength is the maximum length of a plugin ID.
+const MaxIDLength = 256
+
+// idPattern matches valid plugin IDs. They consist of segments separated
+// by "/" which may contain letters, digits and ".", "_", "~", "@", "+", "-".
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9._~@+-]+(/[A-Za-z0-9._~@+-]+)*$`)
+
+// ValidateID checks if the ID can be used as plugin ID.
+func ValidateID(ID string) error {
+	if ID == "" {
+		return checkpoint.Wrap(errors.New("the id is empty"), ErrInvalidPluginInfo)
+	}
+
+	if len(ID) > MaxIDLength {
+		return checkpoint.Wrap(fmt.Errorf("the id %.32v... is longer than %v characters", ID, MaxIDLength), ErrInvalidPluginInfo)
+	}
+
+	if !idPattern.MatchString(ID) {
+		return checkpoint.Wrap(fmt.Errorf("the id %q contains invalid characters", ID), ErrInvalidPluginInfo)
+	}
+
+	return nil
+}
+
+// validateInfo checks the information returned by the plugin.
+func validateInfo(info PluginInfo) error {
+	err := ValidateID(info.ID)
+	if err != nil {
+		return err
+	}
+
+	if info.Homepage != "" {
+		homepage, err := url.Parse(info.Homepage)
+		if err != nil || (homepage.Scheme != "http" && homepage.Scheme != "https") || homepage.Host == "" {
+			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: the homepage %q is no http(s) url", info.ID, info.Homepage), ErrInvalidPluginInfo)
+		}
+	}
+
+	for _, tag := range info.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\n") {
+			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: the tag %q is empty or contains whitespace", info.ID, tag), ErrInvalidPluginInfo)
+		}
+	}
+
+	for _, author := range info.Authors {
+		if strings.TrimSpace(author) == "" {
+			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: an author is empty", info.ID), ErrInvalidPluginInfo)
+		}
+	}
+
+	if info.Interactive && info.PluginType != OneShot {
+		return checkpoint.Wrap(fmt.Errorf("PluginID: %v: only one_shot plugins can be interactive", info.ID), ErrInvalidPluginInfo)
+	}
+
+	for _, entry := range info.EntryPoints {
+		if entry.Name == "" {
+			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: the name of an entry point is empty", info.ID), ErrInvalidPluginInfo)
+		}
+
+		err := ValidateFlags(entry.Flags)
+		if err != nil {
+			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: entry point %v: %w", info.ID, entry.Name, err), ErrInvalidPluginInfo)
+		}
+	}
+
+	if info.MinGoPlugVersion != "" {
+		_, err := canonicalVersion(info.MinGoPlugVersion)
+		if err != nil {
+			return checkpoint.Wrap(fmt.Errorf("PluginID: %v: min goplug version: %w", info.ID, err), ErrInvalidPluginInfo)
+		}
+	}
+
+	return nil
+}
+
+// duplicateIDs returns an error for each plugin which uses the same ID as
+// a plugin before it. The other plugins are returned.
+func duplicateIDs(plugins []*plugin) ([]*plugin, []pluginError) {
+	var errs []pluginError
+	var unique []*plugin
+
+	byID := make(map[string]*plugin)
+	for _, p := range plugins {
+		if other, ok := byID[p.ID]; ok {
+			errs = append(errs, pluginError{p, checkpoint.From(fmt.Errorf("PluginID: %v: %v: %w", p.ID, other.filePath, ErrDuplicatePluginID))})
+			continue
+		}
+
+		byID[p.ID] = p
+		unique = append(unique, p)
+	}
+
+	return unique, errs
+}
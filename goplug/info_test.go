@@ -0,0 +1,225 @@
+package goplug
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		ID      string
+		wantErr bool
+	}{
+		{name: "simple", ID: "plugin"},
+		{name: "segments", ID: "github.com/aligator/plugin"},
+		{name: "special characters", ID: "my_plugin-1.0~beta+x@y"},
+		{name: "max length", ID: strings.Repeat("a", MaxIDLength)},
+		{name: "empty", ID: "", wantErr: true},
+		{name: "too long", ID: strings.Repeat("a", MaxIDLength+1), wantErr: true},
+		{name: "whitespace", ID: "my plugin", wantErr: true},
+		{name: "leading slash", ID: "/plugin", wantErr: true},
+		{name: "trailing slash", ID: "plugin/", wantErr: true},
+		{name: "empty segment", ID: "a//b", wantErr: true},
+		{name: "invalid character", ID: "plugin?", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateID(tt.ID)
+			if tt.wantErr && !errors.Is(err, ErrInvalidPluginInfo) {
+				t.Errorf("ValidateID(%q) error = %v, want %v", tt.ID, err, ErrInvalidPluginInfo)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("ValidateID(%q) error = %v", tt.ID, err)
+			}
+		})
+	}
+}
+
+func TestValidateInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    PluginInfo
+		wantErr error
+	}{
+		{
+			name: "minimal",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot},
+		},
+		{
+			name: "complete",
+			info: PluginInfo{
+				ID:               "plugin",
+				PluginType:       OneShot,
+				Homepage:         "https://example.com/plugin",
+				Tags:             []string{"one", "two"},
+				Authors:          []string{"Jane Doe"},
+				MinGoPlugVersion: "0.1.0",
+				Interactive:      true,
+				EntryPoints: []EntryPoint{{
+					Name: "run",
+					Flags: []Flag{
+						{Name: "upper", Shorthand: "u", Type: FlagBool},
+						{Name: "count", Shorthand: "c", Type: FlagInt},
+					},
+				}},
+			},
+		},
+		{
+			name:    "invalid id",
+			info:    PluginInfo{ID: "my plugin", PluginType: OneShot},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name:    "homepage without scheme",
+			info:    PluginInfo{ID: "plugin", PluginType: OneShot, Homepage: "example.com"},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name:    "homepage with other scheme",
+			info:    PluginInfo{ID: "plugin", PluginType: OneShot, Homepage: "ftp://example.com"},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name:    "empty tag",
+			info:    PluginInfo{ID: "plugin", PluginType: OneShot, Tags: []string{""}},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name:    "tag with whitespace",
+			info:    PluginInfo{ID: "plugin", PluginType: OneShot, Tags: []string{"two words"}},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name:    "empty author",
+			info:    PluginInfo{ID: "plugin", PluginType: OneShot, Authors: []string{" "}},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name:    "invalid min goplug version",
+			info:    PluginInfo{ID: "plugin", PluginType: OneShot, MinGoPlugVersion: "latest"},
+			wantErr: ErrInvalidVersion,
+		},
+		{
+			name:    "interactive listener",
+			info:    PluginInfo{ID: "plugin", PluginType: Listener, Interactive: true},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name: "empty entry point name",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Short: "runs the plugin",
+			}}},
+			wantErr: ErrInvalidPluginInfo,
+		},
+		{
+			name: "duplicate flag",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Name:  "run",
+				Flags: []Flag{{Name: "upper"}, {Name: "upper"}},
+			}}},
+			wantErr: ErrInvalidFlag,
+		},
+		{
+			name: "duplicate shorthand",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Name:  "run",
+				Flags: []Flag{{Name: "upper", Shorthand: "u"}, {Name: "unique", Shorthand: "u"}},
+			}}},
+			wantErr: ErrInvalidFlag,
+		},
+		{
+			name: "long shorthand",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Name:  "run",
+				Flags: []Flag{{Name: "upper", Shorthand: "up"}},
+			}}},
+			wantErr: ErrInvalidFlag,
+		},
+		{
+			name: "non ascii shorthand",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Name:  "run",
+				Flags: []Flag{{Name: "upper", Shorthand: "ü"}},
+			}}},
+			wantErr: ErrInvalidFlag,
+		},
+		{
+			name: "help flag",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Name:  "run",
+				Flags: []Flag{{Name: "help"}},
+			}}},
+			wantErr: ErrInvalidFlag,
+		},
+		{
+			name: "help shorthand",
+			info: PluginInfo{ID: "plugin", PluginType: OneShot, EntryPoints: []EntryPoint{{
+				Name:  "run",
+				Flags: []Flag{{Name: "height", Shorthand: "h"}},
+			}}},
+			wantErr: ErrInvalidFlag,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInfo(tt.info)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("validateInfo() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validateInfo() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && !errors.Is(err, ErrInvalidPluginInfo) {
+				t.Errorf("validateInfo() error = %v, want %v", err, ErrInvalidPluginInfo)
+			}
+		})
+	}
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		plugins    []*plugin
+		wantUnique []string
+		wantErrs   []string
+	}{
+		{
+			name: "no plugins",
+		},
+		{
+			name:       "unique",
+			plugins:    []*plugin{testPlugin("a", "/a"), testPlugin("b", "/b")},
+			wantUnique: []string{"/a", "/b"},
+		},
+		{
+			name:       "first one wins",
+			plugins:    []*plugin{testPlugin("a", "/a2"), testPlugin("a", "/a1"), testPlugin("b", "/b")},
+			wantUnique: []string{"/a2", "/b"},
+			wantErrs:   []string{"/a1"},
+		},
+		{
+			name:       "several duplicates",
+			plugins:    []*plugin{testPlugin("a", "/a1"), testPlugin("a", "/a2"), testPlugin("a", "/a3")},
+			wantUnique: []string{"/a1"},
+			wantErrs:   []string{"/a2", "/a3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unique, errs := duplicateIDs(tt.plugins)
+
+			assertPaths(t, "unique", pluginPaths(unique), tt.wantUnique)
+			assertPaths(t, "errors", errorPaths(errs), tt.wantErrs)
+			for _, e := range errs {
+				if !errors.Is(e.err, ErrDuplicatePluginID) {
+					t.Errorf("error of %v = %v, want %v", e.plugin.filePath, e.err, ErrDuplicatePluginID)
+				}
+			}
+		})
+	}
+}
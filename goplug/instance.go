@@ -0,0 +1,291 @@
+package goplug
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/aligator/checkpoint"
+	"github.com/aligator/goplug/common"
+)
+
+// instance is a running plugin process which is connected to the host
+// using jsonrpc and the stream side channel.
+type instance struct {
+	plugin      *plugin
+	cmd         *exec.Cmd
+	hostControl *HostControl
+
+	// control contains the control messages sent to the process.
+	control *controlQueue
+
+	// done gets closed as soon as the process exited.
+	done chan struct{}
+
+	// err is the result of the process.
+	// Only read it after done is closed.
+	err error
+}
+
+// start starts the plugin with the given arguments and connects it to
+// the host. It does not wait for the process to exit.
+// The entryPoint is passed to the plugin if it is not empty.
+// The reason is passed to the lifecycle notifications of the host.
+func (g *GoPlug) start(p *plugin, entryPoint string, args []string, reason Reason) (*instance, error) {
+	ID := p.ID
+	if g.isShutdown() {
+		return nil, checkpoint.From(fmt.Errorf("PluginID: %v: %w", ID, ErrShutdown))
+	}
+
+	// Plugins loaded lazily are validated on their first start.
+	err := g.activate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(p.filePath, args...)
+	setProcessAttributes(cmd, p.isInteractive())
+
+	// Tell the plugin to connect to the host.
+	// It requests its config through the rpc.
+	cmd.Env = append(os.Environ(), modeEnv+"="+connectMode)
+	if entryPoint != "" {
+		cmd.Env = append(cmd.Env, entryPointEnv+"="+entryPoint)
+	}
+
+	// Create the side channel used for streams.
+	// The plugin gets it as additional file descriptors.
+	streamIn, pluginStreamOut, err := os.Pipe()
+	if err != nil {
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+	}
+
+	pluginStreamIn, streamOut, err := os.Pipe()
+	if err != nil {
+		_ = streamIn.Close()
+		_ = pluginStreamOut.Close()
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+	}
+
+	closeSideChannel := func() {
+		_ = streamIn.Close()
+		_ = streamOut.Close()
+	}
+
+	// The order has to match streamInFd and streamOutFd.
+	cmd.ExtraFiles = []*os.File{pluginStreamIn, pluginStreamOut}
+	streamMux := newStreamMux(streamIn, streamOut)
+
+	var rpcConn common.CombinedReadWriter
+	if p.isInteractive() {
+		// Interactive plugins get the terminal of the host and use
+		// additional pipes for the rpc.
+		connectTerminal(cmd)
+
+		var pluginRPC []*os.File
+		rpcConn, pluginRPC, err = rpcPipes()
+		if err != nil {
+			closePluginFiles(cmd)
+			closeSideChannel()
+			return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+		}
+
+		// The order has to match rpcInFd and rpcOutFd.
+		cmd.ExtraFiles = append(cmd.ExtraFiles, pluginRPC...)
+
+		closeStreams := closeSideChannel
+		closeSideChannel = func() {
+			closeStreams()
+			_ = rpcConn.Close()
+		}
+	} else {
+		// Connect stdin and stdout
+		outPipe, err := cmd.StdoutPipe()
+		if err != nil {
+			closePluginFiles(cmd)
+			closeSideChannel()
+			return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+		}
+
+		inPipe, err := cmd.StdinPipe()
+		if err != nil {
+			closePluginFiles(cmd)
+			closeSideChannel()
+			return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+		}
+
+		// Use a CombinedReadWriter which combines the two pipes.
+		rpcConn = common.CombinedReadWriter{
+			In:  outPipe,
+			Out: inPipe,
+		}
+
+		// Connect stderr directly to the host stderr to
+		// still allow to receive panics and errors of the plugin.
+		cmd.Stderr = os.Stderr
+	}
+	codec := jsonrpc.NewServerCodec(rpcConn)
+
+	done := make(chan struct{})
+	control := newControlQueue()
+	i := &instance{
+		plugin: p,
+		cmd:    cmd,
+		hostControl: &HostControl{
+			GoPlug:   g,
+			plugin:   p,
+			streams:  streamMux,
+			registry: newStreamRegistry(),
+			control:  control,
+			done:     done,
+		},
+		control: control,
+		done:    done,
+	}
+
+	// The plugin receives the start message as first control message.
+	control.push(newControlDelivery(ControlStart, reason))
+
+	s := rpc.NewServer()
+
+	// Register the host specific actions.
+	// They only resolve the streams of this plugin process.
+	actions := g.Actions
+	if binder, ok := actions.(StreamBinder); ok {
+		actions = binder.BindStreams(i.hostControl.registry)
+	}
+	err = s.RegisterName("Host", actions)
+	if err != nil {
+		closePluginFiles(cmd)
+		closeSideChannel()
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+	}
+
+	// Register actions available to all plugins.
+	err = s.RegisterName("HostControl", i.hostControl)
+	if err != nil {
+		closePluginFiles(cmd)
+		closeSideChannel()
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+	}
+
+	// Start the plugin.
+	err = cmd.Start()
+
+	// The plugin has its own copy of the side channel now.
+	closePluginFiles(cmd)
+
+	if err != nil {
+		closeSideChannel()
+		return nil, checkpoint.Wrap(fmt.Errorf("PluginID: %v: %w", ID, err), ErrCallingPlugin)
+	}
+
+	// Shutdown sets the shutdown flag and takes its snapshot of the running
+	// instances under the same lock, so the instance is either stopped by
+	// Shutdown or refused here.
+	g.runningMutex.Lock()
+	if g.isShutdown() {
+		g.runningMutex.Unlock()
+		_ = killProcess(cmd)
+		_ = cmd.Wait()
+		closeSideChannel()
+		return nil, checkpoint.From(fmt.Errorf("PluginID: %v: %w", ID, ErrShutdown))
+	}
+	if g.running == nil {
+		g.running = make(map[*instance]*plugin)
+	}
+	g.running[i] = p
+	g.runningMutex.Unlock()
+
+	// Start the jsonrpc server.
+	go func() {
+		s.ServeCodec(codec)
+	}()
+
+	go func() {
+		_ = streamMux.serve()
+	}()
+
+	p.mutex.Lock()
+	if p.instances == nil {
+		p.instances = make(map[*instance]struct{})
+	}
+	p.instances[i] = struct{}{}
+	p.startedAt = time.Now()
+	p.starts++
+	p.mutex.Unlock()
+
+	g.notifyStarted(p, reason)
+
+	go func() {
+		i.err = cmd.Wait()
+		closeSideChannel()
+		i.hostControl.closeStreams()
+
+		p.mutex.Lock()
+		delete(p.instances, i)
+		p.crashed = i.err != nil
+		if i.err != nil {
+			p.lastErr = i.err
+		}
+		p.mutex.Unlock()
+
+		g.runningMutex.Lock()
+		delete(g.running, i)
+		g.runningMutex.Unlock()
+
+		g.notifyExited(p, i.err)
+		close(i.done)
+	}()
+
+	return i, nil
+}
+
+// closePluginFiles closes the ends of the pipes which are passed to the
+// plugin. The plugin has its own copy of them once it is started.
+func closePluginFiles(cmd *exec.Cmd) {
+	for _, file := range cmd.ExtraFiles {
+		_ = file.Close()
+	}
+}
+
+// kill stops the plugin process immediately.
+func (i *instance) kill() error {
+	select {
+	case <-i.done:
+		return nil
+	default:
+	}
+
+	err := killProcess(i.cmd)
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return checkpoint.From(err)
+}
+
+// signal sends the signal to the plugin process.
+// It does nothing if the process already exited.
+func (i *instance) signal(sig os.Signal) error {
+	select {
+	case <-i.done:
+		return nil
+	default:
+	}
+
+	err := signalProcess(i.cmd, sig)
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return checkpoint.From(err)
+}
+
+// wait blocks until the plugin process exited.
+func (i *instance) wait() error {
+	<-i.done
+	return i.err
+}
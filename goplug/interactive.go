@@ -0,0 +1,82 @@
+// File interactive.go contains the interactive mode of OneShot plugins.
+// Interactive plugins get the terminal of the host as stdin, stdout and
+// stderr, so they can prompt the user, use colors and detect a tty.
+// As stdin and stdout are not available for the rpc anymore, it uses
+// additional file descriptors in this mode.
+//
+// The plugin stays in the foreground process group of the host. Therefore
+// it receives the signals of the terminal, like SIGINT and SIGWINCH,
+// directly and decides by itself how to handle them.
+
+package goplug
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/aligator/goplug/common"
+)
+
+// interactiveEnv is the environment variable which tells the plugin that
+// it was started in interactive mode.
+const interactiveEnv = "GOPLUG_INTERACTIVE"
+
+const (
+	// rpcInFd is the file descriptor the interactive plugin receives the
+	// rpc responses on.
+	rpcInFd = 5
+	// rpcOutFd is the file descriptor the interactive plugin sends the
+	// rpc requests to.
+	rpcOutFd = 6
+)
+
+// isInteractive checks if the plugin gets the terminal of the host.
+// Only OneShot plugins may be interactive, as Listeners run in the
+// background.
+func (p *plugin) isInteractive() bool {
+	return p.Interactive && p.PluginType == OneShot
+}
+
+// connectTerminal passes the terminal of the host to the plugin.
+func connectTerminal(cmd *exec.Cmd) {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(cmd.Env, interactiveEnv+"=1")
+}
+
+// rpcPipes creates the pipes for the rpc of an interactive plugin.
+// The returned files are the ends of the plugin. They have to be passed
+// as rpcInFd and rpcOutFd and closed after the plugin was started.
+func rpcPipes() (common.CombinedReadWriter, []*os.File, error) {
+	hostIn, pluginOut, err := os.Pipe()
+	if err != nil {
+		return common.CombinedReadWriter{}, nil, err
+	}
+
+	pluginIn, hostOut, err := os.Pipe()
+	if err != nil {
+		_ = hostIn.Close()
+		_ = pluginOut.Close()
+		return common.CombinedReadWriter{}, nil, err
+	}
+
+	return common.CombinedReadWriter{In: hostIn, Out: hostOut}, []*os.File{pluginIn, pluginOut}, nil
+}
+
+// isInteractiveClient checks if the host started the plugin in
+// interactive mode. It is removed from the environment so that processes
+// started by the plugin do not inherit it.
+func isInteractiveClient() bool {
+	interactive := os.Getenv(interactiveEnv) != ""
+	_ = os.Unsetenv(interactiveEnv)
+	return interactive
+}
+
+// newRPCFromFds connects to the rpc pipes of an interactive plugin.
+func newRPCFromFds() common.CombinedReadWriter {
+	return common.CombinedReadWriter{
+		In:  os.NewFile(rpcInFd, "goplug-rpc-in"),
+		Out: os.NewFile(rpcOutFd, "goplug-rpc-out"),
+	}
+}
@@ -0,0 +1,246 @@
+// File lazy.go contains the lazy activation of plugins.
+// In lazy mode, Init reads the plugin information from manifests or from a
+// cache instead of starting each plugin. The plugins are validated when they
+// are used for the first time.
+
+package goplug
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+// DefaultCacheFile is the name of the cache file inside of the
+// PluginFolder which is used if GoPlug.CacheFile is not set.
+const DefaultCacheFile = ".goplug-cache.json"
+
+// ManifestSuffix is appended to the name of a plugin binary to get the
+// name of its manifest. A manifest contains the plugin information as it
+// is returned by the plugin in discovery mode.
+// For example, it can be created using
+//
+//	GOPLUG_MODE=discover ./plugin > ./plugin.manifest.json
+const ManifestSuffix = ".manifest.json"
+
+// cacheEntry is the cached plugin information of a plugin binary.
+// It is only valid as long as the binary does not change.
+type cacheEntry struct {
+	ModTime time.Time  `json:"mod_time"`
+	Size    int64      `json:"size"`
+	Info    PluginInfo `json:"info"`
+}
+
+// cacheFile returns the path of the cache file.
+func (g *GoPlug) cacheFile() string {
+	if g.CacheFile != "" {
+		return g.CacheFile
+	}
+	return path.Join(g.PluginFolder, DefaultCacheFile)
+}
+
+// loadCache reads the cache file.
+// A missing or invalid cache is treated as empty.
+func (g *GoPlug) loadCache() {
+	g.cacheMutex.Lock()
+	defer g.cacheMutex.Unlock()
+
+	g.cache = make(map[string]cacheEntry)
+	if !g.Lazy {
+		return
+	}
+
+	data, err := ioutil.ReadFile(g.cacheFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Println("could not read the plugin cache:", err)
+		return
+	}
+
+	err = json.Unmarshal(data, &g.cache)
+	if err != nil {
+		log.Println("could not read the plugin cache:", err)
+		g.cache = make(map[string]cacheEntry)
+	}
+}
+
+// saveCache writes the cache entries of all plugin files which still
+// exist to the cache file.
+func (g *GoPlug) saveCache() error {
+	if !g.Lazy {
+		return nil
+	}
+
+	g.filesMutex.Lock()
+	g.cacheMutex.Lock()
+	for filePath := range g.cache {
+		if _, ok := g.files[filePath]; !ok {
+			delete(g.cache, filePath)
+		}
+	}
+	data, err := json.MarshalIndent(g.cache, "", "  ")
+	g.cacheMutex.Unlock()
+	g.filesMutex.Unlock()
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	return writeFileAtomic(g.cacheFile(), data, 0644)
+}
+
+// cacheTempSuffix is the suffix of the temporary files of the cache.
+const cacheTempSuffix = ".tmp"
+
+// writeFileAtomic writes the data to a temporary file next to the file and
+// renames it afterwards. This way readers never see a partially written file.
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".*"+cacheTempSuffix)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return checkpoint.From(err)
+	}
+	return nil
+}
+
+// readManifest reads the manifest of the plugin binary.
+// It returns nil if the plugin has no manifest.
+func readManifest(filePath string) (*PluginInfo, error) {
+	data, err := ioutil.ReadFile(filePath + ManifestSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, checkpoint.From(err)
+	}
+
+	var info PluginInfo
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
+	return &info, nil
+}
+
+// load returns the plugin at filePath.
+// If Lazy is not set, the plugin gets discovered.
+// Otherwise a not yet activated plugin is returned. Its information is read
+// from the manifest or a valid cache entry. If the plugin has neither, it is
+// started in discovery mode and its information is added to the cache.
+func (g *GoPlug) load(filePath string) (*plugin, error) {
+	if !g.Lazy {
+		return g.discover(filePath)
+	}
+
+	info, err := readManifest(filePath)
+	if err != nil {
+		return nil, newFailure(filePath, nil, PhaseDecode, err)
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, newFailure(filePath, nil, PhaseExec, checkpoint.From(err))
+	}
+
+	if info == nil {
+		g.cacheMutex.Lock()
+		entry, ok := g.cache[filePath]
+		g.cacheMutex.Unlock()
+		if ok && entry.ModTime.Equal(stat.ModTime()) && entry.Size == stat.Size() {
+			info = &entry.Info
+		}
+	}
+
+	if info != nil {
+		p := &plugin{
+			PluginInfo: *info,
+			filePath:   filePath,
+			lazy:       true,
+		}
+
+		err := validateInfo(p.PluginInfo)
+		if err != nil {
+			return p, newFailure(filePath, p, PhaseValidation, err)
+		}
+		return p, nil
+	}
+
+	p, err := g.probe(filePath)
+	if err != nil {
+		return p, err
+	}
+
+	g.cacheMutex.Lock()
+	g.cache[filePath] = cacheEntry{
+		ModTime: stat.ModTime(),
+		Size:    stat.Size(),
+		Info:    p.PluginInfo,
+	}
+	g.cacheMutex.Unlock()
+
+	p.lazy = true
+	return p, nil
+}
+
+// activate validates a plugin which was loaded lazily when it
+// is used for the first time. The result is remembered, so a plugin which
+// cannot be used returns the same error on each use.
+func (g *GoPlug) activate(p *plugin) error {
+	if !p.lazy {
+		return nil
+	}
+
+	p.activateOnce.Do(func() {
+		p.activateErr = g.validate(p)
+		if p.activateErr != nil {
+			p.mutex.Lock()
+			p.lastErr = p.activateErr
+			p.crashed = true
+			p.mutex.Unlock()
+		}
+	})
+	return p.activateErr
+}
+
+// isPluginFile checks if the file inside of the PluginFolder belongs to a
+// plugin binary and not to one of the files managed by goplug.
+func (g *GoPlug) isPluginFile(name string) bool {
+	if strings.HasSuffix(name, ManifestSuffix) {
+		return false
+	}
+
+	cacheFile := filepath.Clean(g.cacheFile())
+	filePath := filepath.Clean(filepath.Join(g.PluginFolder, name))
+	if filePath == cacheFile {
+		return false
+	}
+
+	// saveCache writes the cache to a temporary file next to it first.
+	if filepath.Dir(filePath) == filepath.Dir(cacheFile) &&
+		strings.HasPrefix(name, filepath.Base(cacheFile)+".") &&
+		strings.HasSuffix(name, cacheTempSuffix) {
+		return false
+	}
+	return true
+}
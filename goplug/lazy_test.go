@@ -0,0 +1,234 @@
+package goplug
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadLazy(t *testing.T) {
+	const info = `{"id":"plugin","plugin_type":"one_shot","description":"discovered"}`
+
+	tests := []struct {
+		name string
+
+		// prepare is called after the plugin was loaded once and before
+		// it is loaded again.
+		prepare func(t *testing.T, filePath string)
+
+		manifest         string
+		wantDescription  string
+		wantDiscoveries  int
+		wantFailurePhase Phase
+	}{
+		{
+			name:            "cache hit",
+			wantDescription: "discovered",
+			wantDiscoveries: 1,
+		},
+		{
+			name:            "manifest",
+			manifest:        `{"id":"plugin","plugin_type":"one_shot","description":"manifest"}`,
+			wantDescription: "manifest",
+			wantDiscoveries: 0,
+		},
+		{
+			name:             "invalid manifest",
+			manifest:         `{"id":`,
+			wantFailurePhase: PhaseDecode,
+		},
+		{
+			name:             "manifest with invalid info",
+			manifest:         `{"id":"invalid id","plugin_type":"one_shot"}`,
+			wantFailurePhase: PhaseValidation,
+		},
+		{
+			name: "size changed",
+			prepare: func(t *testing.T, filePath string) {
+				appendToFile(t, filePath, "# changed\n")
+			},
+			wantDescription: "discovered",
+			wantDiscoveries: 2,
+		},
+		{
+			name: "mod time changed",
+			prepare: func(t *testing.T, filePath string) {
+				stat, err := os.Stat(filePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				modTime := stat.ModTime().Add(time.Minute)
+				err = os.Chtimes(filePath, modTime, modTime)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDescription: "discovered",
+			wantDiscoveries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			countFile := filepath.Join(t.TempDir(), "count")
+			filePath := writeDiscoveryScript(t, folder, "plugin", countFile, info)
+			if tt.manifest != "" {
+				err := ioutil.WriteFile(filePath+ManifestSuffix, []byte(tt.manifest), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			g := &GoPlug{
+				PluginFolder: folder,
+				Lazy:         true,
+			}
+			g.loadCache()
+
+			// The first load fills the cache.
+			_, _ = g.load(filePath)
+			if tt.prepare != nil {
+				tt.prepare(t, filePath)
+			}
+
+			p, err := g.load(filePath)
+			if tt.wantFailurePhase != "" {
+				var failure *PluginFailure
+				if !errors.As(err, &failure) || failure.Phase != tt.wantFailurePhase {
+					t.Fatalf("load() error = %v, want a failure in phase %v", err, tt.wantFailurePhase)
+				}
+				if got := discoveryCount(t, countFile); got != 0 {
+					t.Errorf("discovered %v times, want 0", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !p.lazy {
+				t.Error("the plugin is not loaded lazily")
+			}
+			if p.Description != tt.wantDescription {
+				t.Errorf("Description = %v, want %v", p.Description, tt.wantDescription)
+			}
+			if got := discoveryCount(t, countFile); got != tt.wantDiscoveries {
+				t.Errorf("discovered %v times, want %v", got, tt.wantDiscoveries)
+			}
+		})
+	}
+}
+
+func TestLoadNotLazy(t *testing.T) {
+	folder := t.TempDir()
+	countFile := filepath.Join(t.TempDir(), "count")
+	filePath := writeDiscoveryScript(t, folder, "plugin", countFile, `{"id":"plugin","plugin_type":"one_shot"}`)
+
+	// Manifests are only used in lazy mode.
+	err := ioutil.WriteFile(filePath+ManifestSuffix, []byte(`{"id":"manifest","plugin_type":"one_shot"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &GoPlug{PluginFolder: folder}
+	g.loadCache()
+
+	for i := 1; i <= 2; i++ {
+		p, err := g.load(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.lazy || p.ID != "plugin" {
+			t.Errorf("load() = %v, lazy %v, want plugin, not lazy", p.ID, p.lazy)
+		}
+		if got := discoveryCount(t, countFile); got != i {
+			t.Errorf("discovered %v times, want %v", got, i)
+		}
+	}
+}
+
+func TestInitLazyUsesCache(t *testing.T) {
+	folder := t.TempDir()
+	countFile := filepath.Join(t.TempDir(), "count")
+	writeDiscoveryScript(t, folder, "plugin", countFile, `{"id":"plugin","plugin_type":"one_shot"}`)
+
+	// A temporary file left over by a crash while writing the cache.
+	err := ioutil.WriteFile(filepath.Join(folder, DefaultCacheFile+".123"+cacheTempSuffix), []byte("{"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		g := &GoPlug{
+			PluginFolder: folder,
+			Host:         &startCountingHost{},
+			Actions:      testActions{},
+			Lazy:         true,
+		}
+		report, err := g.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The cache and its temporary files are no plugins.
+		if len(report.Failed) > 0 || len(report.Loaded) != 1 {
+			t.Errorf("Init() loaded %v and failed %v, want only the plugin", len(report.Loaded), report.Failed)
+		}
+	}
+
+	if got := discoveryCount(t, countFile); got != 1 {
+		t.Errorf("discovered %v times, want 1", got)
+	}
+}
+
+func TestIsPluginFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		cacheFile string
+		file      string
+		want      bool
+	}{
+		{name: "plugin", file: "plugin", want: true},
+		{name: "manifest", file: "plugin" + ManifestSuffix, want: false},
+		{name: "cache", file: DefaultCacheFile, want: false},
+		{name: "cache temp file", file: DefaultCacheFile + ".123" + cacheTempSuffix, want: false},
+		{name: "other temp file", file: "plugin" + cacheTempSuffix, want: true},
+		{name: "custom cache", cacheFile: path.Join("plugins", "cache.json"), file: "cache.json", want: false},
+		{name: "custom cache temp file", cacheFile: path.Join("plugins", "cache.json"), file: "cache.json.1" + cacheTempSuffix, want: false},
+		{name: "default cache with custom cache", cacheFile: path.Join("plugins", "cache.json"), file: DefaultCacheFile, want: true},
+		{name: "cache outside of the folder", cacheFile: "cache.json", file: "cache.json", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GoPlug{
+				PluginFolder: "plugins",
+				CacheFile:    tt.cacheFile,
+			}
+			if got := g.isPluginFile(tt.file); got != tt.want {
+				t.Errorf("isPluginFile(%v) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+// appendToFile appends the text to the file.
+func appendToFile(t *testing.T, filePath string, text string) {
+	t.Helper()
+
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,128 @@
+// File lifecycle.go contains the optional Host interfaces which get
+// notified about the lifecycle of the plugins.
+
+package goplug
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Reason describes why a lifecycle event happened.
+type Reason string
+
+const (
+	// ReasonInvoked means that a OneShot plugin was called by the host.
+	ReasonInvoked = Reason("invoked")
+
+	// ReasonMessage means that a background plugin was started to
+	// receive messages.
+	ReasonMessage = Reason("message")
+
+	// ReasonRestart means that a background plugin was restarted to
+	// receive the messages it did not acknowledge.
+	ReasonRestart = Reason("restart")
+
+	// ReasonExited means that the plugin exited by itself.
+	ReasonExited = Reason("exited")
+
+	// ReasonFailed means that a OneShot plugin exited with a non-zero exit
+	// code, e.g. because it was called with invalid arguments.
+	ReasonFailed = Reason("failed")
+
+	// ReasonRemoved means that the binary of the plugin was removed.
+	ReasonRemoved = Reason("removed")
+
+	// ReasonReplaced means that the binary of the plugin was replaced.
+	ReasonReplaced = Reason("replaced")
+
+	// ReasonUnsatisfied means that a dependency of the plugin was removed
+	// or replaced by an incompatible version.
+	ReasonUnsatisfied = Reason("unsatisfied")
+
+	// ReasonShutdown means that the host shuts down.
+	ReasonShutdown = Reason("shutdown")
+)
+
+// StartedHost can be implemented additionally to Host.
+// It gets notified each time a plugin process was started.
+type StartedHost interface {
+	PluginStarted(info PluginInfo, reason Reason)
+}
+
+// StoppedHost can be implemented additionally to Host.
+// It gets notified each time a plugin process exited successfully.
+// OneShot plugins which exited with a non-zero exit code are reported
+// with ReasonFailed.
+type StoppedHost interface {
+	PluginStopped(info PluginInfo, reason Reason)
+}
+
+// CrashedHost can be implemented additionally to Host.
+// It gets notified each time a plugin process crashed. This is the case if
+// it was killed by a signal or if a background plugin exited with an error.
+// The reason contains the error of the process.
+type CrashedHost interface {
+	PluginCrashed(info PluginInfo, reason error)
+}
+
+// UnloadedHost can be implemented additionally to Host.
+// It gets notified each time a plugin was unloaded by GoPlug.Watch.
+// Running instances of the plugin may still exist.
+type UnloadedHost interface {
+	PluginUnloaded(info PluginInfo, reason Reason)
+}
+
+// The following methods notify the Host if it implements the respective
+// interface. They may be called concurrently, so the Host has to
+// synchronize them if needed.
+
+func (g *GoPlug) notifyStarted(p *plugin, reason Reason) {
+	if host, ok := g.Host.(StartedHost); ok {
+		host.PluginStarted(p.PluginInfo, reason)
+	}
+}
+
+func (g *GoPlug) notifyExited(p *plugin, err error) {
+	if isCrash(p, err) {
+		if host, ok := g.Host.(CrashedHost); ok {
+			host.PluginCrashed(p.PluginInfo, err)
+		}
+		return
+	}
+
+	reason := ReasonExited
+	if err != nil {
+		reason = ReasonFailed
+	}
+
+	if host, ok := g.Host.(StoppedHost); ok {
+		host.PluginStopped(p.PluginInfo, reason)
+	}
+}
+
+// isCrash checks if the exit error of a plugin process is a crash.
+// OneShot plugins may report errors through their exit code, so only a
+// death by a signal counts as crash for them.
+func isCrash(p *plugin, err error) bool {
+	if err == nil {
+		return false
+	}
+	if p.PluginType != OneShot {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return true
+	}
+
+	// ExitCode is -1 if the process was terminated by a signal.
+	return exitErr.ExitCode() == -1
+}
+
+func (g *GoPlug) notifyUnloaded(p *plugin, reason Reason) {
+	if host, ok := g.Host.(UnloadedHost); ok {
+		host.PluginUnloaded(p.PluginInfo, reason)
+	}
+}
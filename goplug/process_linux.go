@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package goplug
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// terminalSignals are sent by the terminal to interrupt the foreground
+// process group.
+var terminalSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT}
+
+// DefaultForwardSignals is used if GoPlug.ForwardSignals is not set.
+var DefaultForwardSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// setProcessAttributes makes sure the plugin gets killed if the host dies,
+// so that no orphaned plugins are left behind.
+// Linux sends the Pdeathsig when the OS thread which started the plugin
+// exits, not the whole process. The Go runtime only terminates a thread if
+// a goroutine exits while it is locked to it with runtime.LockOSThread.
+// So plugins should not be invoked from such goroutines, as they would get
+// killed together with the thread.
+//
+// Plugins which do not run in the foreground get their own process group.
+// This way the signals of the terminal only reach the host, which forwards
+// them to the process group of the plugin.
+func setProcessAttributes(cmd *exec.Cmd, foreground bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Pdeathsig: syscall.SIGKILL,
+		Setpgid:   !foreground,
+	}
+}
+
+// signalProcess sends the signal to the process group of the plugin, so
+// that the processes started by the plugin receive it as well.
+// Plugins in the foreground share the process group of the host, so only
+// the plugin process itself gets the signal.
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok || cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		return cmd.Process.Signal(sig)
+	}
+
+	err := syscall.Kill(-cmd.Process.Pid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// killProcess kills the plugin and all processes in its process group.
+func killProcess(cmd *exec.Cmd) error {
+	return signalProcess(cmd, syscall.SIGKILL)
+}
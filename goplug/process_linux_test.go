@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package goplug
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// isProcessGone checks if the process does not exist anymore or is a zombie
+// waiting to be reaped.
+func isProcessGone(pid int) bool {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+
+	// The state follows the command name in parentheses.
+	fields := strings.Fields(string(data[strings.LastIndex(string(data), ")")+1:]))
+	return len(fields) > 0 && (fields[0] == "Z" || fields[0] == "X")
+}
+
+func TestInterruptStopsChildProcesses(t *testing.T) {
+	tests := []struct {
+		name      string
+		interrupt func(i *instance) error
+	}{
+		{
+			name: "signal",
+			interrupt: func(i *instance) error {
+				return i.signal(syscall.SIGTERM)
+			},
+		},
+		{
+			name: "kill",
+			interrupt: func(i *instance) error {
+				return i.kill()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			pidFile := filepath.Join(folder, "child.pid")
+
+			g := &GoPlug{
+				Host:    &startCountingHost{},
+				Actions: testActions{},
+			}
+			p := &plugin{
+				PluginInfo: PluginInfo{ID: "parent", PluginType: OneShot},
+				filePath:   writeScript(t, folder, "parent", "sleep 30 &\necho $! > "+pidFile+"\nwait"),
+			}
+
+			i, err := g.start(p, "", nil, ReasonInvoked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = i.kill() }()
+
+			var child int
+			for deadline := time.Now().Add(5 * time.Second); child == 0; {
+				data, _ := ioutil.ReadFile(pidFile)
+				child, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+				if child == 0 && time.Now().After(deadline) {
+					t.Fatal("the plugin did not start its child process")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			err = tt.interrupt(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case <-i.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the plugin did not exit")
+			}
+
+			for deadline := time.Now().Add(5 * time.Second); !isProcessGone(child); {
+				if time.Now().After(deadline) {
+					_ = syscall.Kill(child, syscall.SIGKILL)
+					t.Fatalf("the child process %v of the plugin is still running", child)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
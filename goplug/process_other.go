@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package goplug
+
+import (
+	"os"
+	"os/exec"
+)
+
+// terminalSignals are sent by the terminal to interrupt the foreground
+// process group.
+var terminalSignals = []os.Signal{os.Interrupt}
+
+// DefaultForwardSignals is used if GoPlug.ForwardSignals is not set.
+var DefaultForwardSignals = []os.Signal{os.Interrupt}
+
+// setProcessAttributes does nothing, as killing the plugin if the host
+// dies is only supported on linux.
+func setProcessAttributes(cmd *exec.Cmd, foreground bool) {}
+
+// signalProcess sends the signal to the plugin process.
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Signal(sig)
+}
+
+// killProcess kills the plugin process.
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,101 @@
+// File report.go contains the report returned by Init which lists the
+// loaded plugins and the plugins which could not be loaded.
+
+package goplug
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Phase is the step of loading a plugin in which an error happened.
+type Phase string
+
+const (
+	// PhaseExec means that the plugin could not be executed in
+	// discovery mode or exited with an error.
+	PhaseExec = Phase("exec")
+
+	// PhaseDecode means that the plugin information returned by the
+	// plugin is not valid json.
+	PhaseDecode = Phase("decode")
+
+	// PhaseValidation means that the plugin information is not valid,
+	// e.g. because the ID is malformed or used by another plugin.
+	PhaseValidation = Phase("validation")
+
+	// PhaseCompatibility means that the plugin is not compatible with
+	// the host API.
+	PhaseCompatibility = Phase("compatibility")
+
+	// PhaseConfig means that the config of the plugin is not valid.
+	PhaseConfig = Phase("config")
+
+	// PhaseDependencies means that the dependencies of the plugin are
+	// not satisfied.
+	PhaseDependencies = Phase("dependencies")
+
+	// PhaseRegistration means that the plugin could not be registered,
+	// e.g. because the host returned an error.
+	PhaseRegistration = Phase("registration")
+)
+
+// PluginFailure is the error of a single plugin which could not be loaded.
+// It supports errors.Is and errors.As for the underlying error.
+type PluginFailure struct {
+	// Path is the path of the plugin binary.
+	Path string
+
+	// ID is empty if the plugin information could not be read.
+	ID string
+
+	Phase Phase
+	Err   error
+}
+
+// newFailure creates a PluginFailure for the plugin at filePath.
+// p may be nil if the plugin information is not known.
+func newFailure(filePath string, p *plugin, phase Phase, err error) *PluginFailure {
+	failure := &PluginFailure{
+		Path:  filePath,
+		Phase: phase,
+		Err:   err,
+	}
+	if p != nil {
+		failure.ID = p.ID
+	}
+	return failure
+}
+
+func (f *PluginFailure) Error() string {
+	return fmt.Sprintf("%v: %v: %v", f.Path, f.Phase, f.Err)
+}
+
+func (f *PluginFailure) Unwrap() error {
+	return f.Err
+}
+
+// InitReport describes the result of GoPlug.Init.
+type InitReport struct {
+	// Loaded contains all registered plugins in the order
+	// of their registration.
+	Loaded []PluginStatus
+
+	// Skipped contains all plugins which are valid but not used by
+	// goplug, e.g. because their plugin type is not supported.
+	Skipped []*PluginFailure
+
+	// Failed contains all plugins which could not be loaded,
+	// sorted by their path.
+	Failed []*PluginFailure
+}
+
+// sortFailures sorts the failures of the report by the path of the plugins.
+func (r *InitReport) sortFailures() {
+	sort.SliceStable(r.Skipped, func(i, j int) bool {
+		return r.Skipped[i].Path < r.Skipped[j].Path
+	})
+	sort.SliceStable(r.Failed, func(i, j int) bool {
+		return r.Failed[i].Path < r.Failed[j].Path
+	})
+}
@@ -0,0 +1,146 @@
+// File shutdown.go contains the graceful shutdown of GoPlug and all
+// running plugins.
+
+package goplug
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrShutdown = errors.New("GoPlug is shut down")
+)
+
+// DefaultShutdownTimeout is the time Close waits for the plugins to exit.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// shutdownChan returns a channel which gets closed by Shutdown.
+func (g *GoPlug) shutdownChan() chan struct{} {
+	g.shutdownMutex.Lock()
+	defer g.shutdownMutex.Unlock()
+
+	if g.shutdownCh == nil {
+		g.shutdownCh = make(chan struct{})
+	}
+	return g.shutdownCh
+}
+
+// isShutdown returns true if Shutdown was called.
+func (g *GoPlug) isShutdown() bool {
+	select {
+	case <-g.shutdownChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops all plugins gracefully.
+//
+// After it is called, no new plugin invocations are accepted and Watch
+// returns. All running plugin processes get notified that the host shuts
+// down, including the processes of plugins which were unloaded or replaced
+// by Watch.
+// Background plugins receive their queued messages and get stopped
+// afterwards, while OneShot plugins can finish their current invocation.
+//
+// If the context is done before all plugins exited, the remaining
+// plugins get killed and the error of the context is returned.
+func (g *GoPlug) Shutdown(ctx context.Context) error {
+	// start registers new instances under the runningMutex, so no instance
+	// can be started between setting the flag and taking the snapshot.
+	g.runningMutex.Lock()
+	g.shutdownMutex.Lock()
+	if g.shutdownCh == nil {
+		g.shutdownCh = make(chan struct{})
+	}
+	select {
+	case <-g.shutdownCh:
+	default:
+		close(g.shutdownCh)
+	}
+	g.shutdownMutex.Unlock()
+
+	running := g.runningInstances()
+	g.runningMutex.Unlock()
+
+	wg := sync.WaitGroup{}
+	for i, p := range running {
+		wg.Add(1)
+		go func(p *plugin, i *instance) {
+			defer wg.Done()
+			g.shutdownInstance(ctx, p, i)
+		}(p, i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Kill all plugins which did not exit in time.
+	for i, p := range running {
+		err := i.kill()
+		if err != nil {
+			log.Println(p.ID, "- could not be killed:", err)
+		}
+	}
+	<-done
+
+	return checkpoint.From(ctx.Err())
+}
+
+// runningInstances returns all running plugin processes together with their
+// plugin. This includes the processes of unloaded and replaced plugins.
+// The runningMutex has to be locked.
+func (g *GoPlug) runningInstances() map[*instance]*plugin {
+	running := make(map[*instance]*plugin, len(g.running))
+	for i, p := range g.running {
+		running[i] = p
+	}
+	return running
+}
+
+// Close shuts down GoPlug and waits at most DefaultShutdownTimeout for the
+// plugins to exit.
+func (g *GoPlug) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+
+	return g.Shutdown(ctx)
+}
+
+// shutdownInstance notifies the plugin process about the shutdown and
+// waits until it exited or the context is done.
+func (g *GoPlug) shutdownInstance(ctx context.Context, p *plugin, i *instance) {
+	err := i.sendControl(ctx, ControlHostShutdown, ReasonShutdown)
+	if err != nil && !errors.Is(err, ErrPluginExited) && !errors.Is(err, ctx.Err()) {
+		log.Println(err)
+	}
+
+	// Background plugins get all queued messages before they are stopped.
+	if p.inbox != nil && p.inbox.waitIdle(ctx.Done()) {
+		err := i.sendControl(ctx, ControlStop, ReasonShutdown)
+		if err != nil && !errors.Is(err, ErrPluginExited) && !errors.Is(err, ctx.Err()) {
+			log.Println(err)
+		}
+	}
+
+	select {
+	case <-i.done:
+	case <-ctx.Done():
+	}
+}
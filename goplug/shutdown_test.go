@@ -0,0 +1,62 @@
+package goplug
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownStopsInstancesStartedConcurrently(t *testing.T) {
+	p := testPlugin("plugin", writeScript(t, t.TempDir(), "plugin", "exec sleep 30"))
+
+	for run := 0; run < 10; run++ {
+		g := &GoPlug{
+			Host:    &startCountingHost{},
+			Actions: testActions{},
+		}
+
+		var mutex sync.Mutex
+		var started []*instance
+
+		wg := sync.WaitGroup{}
+		for n := 0; n < 5; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				// Start instances until the shutdown refuses them.
+				for {
+					i, err := g.start(p, "", nil, ReasonInvoked)
+					if errors.Is(err, ErrShutdown) {
+						return
+					} else if err != nil {
+						t.Error(err)
+						return
+					}
+
+					mutex.Lock()
+					started = append(started, i)
+					mutex.Unlock()
+				}
+			}()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_ = g.Shutdown(ctx)
+		cancel()
+		wg.Wait()
+
+		// Every instance which was started has to be stopped by Shutdown.
+		for _, i := range started {
+			select {
+			case <-i.done:
+			case <-time.After(5 * time.Second):
+				_ = i.kill()
+				t.Fatal("an instance started during the shutdown is still running")
+			}
+		}
+	}
+}
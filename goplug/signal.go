@@ -0,0 +1,152 @@
+// File signal.go contains the forwarding of signals received by the host
+// to running OneShot plugins.
+
+package goplug
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrInterrupted = errors.New("plugin was interrupted by a signal")
+)
+
+// InterruptedError is returned if a OneShot plugin exited after the host
+// received a signal. It matches ErrInterrupted.
+type InterruptedError struct {
+	PluginID string
+
+	// Signal is the last signal received by the host.
+	Signal os.Signal
+
+	// Err is the error of the plugin process, e.g. an *exec.ExitError.
+	// It is nil if the plugin exited successfully.
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("PluginID: %v: %v: %v", e.PluginID, e.Signal, ErrInterrupted)
+	}
+	return fmt.Sprintf("PluginID: %v: %v: %v: %v", e.PluginID, e.Signal, ErrInterrupted, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InterruptedError) Is(target error) bool {
+	return target == ErrInterrupted
+}
+
+// DefaultSignalGracePeriod is used if GoPlug.SignalGracePeriod is not set.
+const DefaultSignalGracePeriod = 3 * time.Second
+
+// forwardSignals returns the signals which are forwarded to the plugins.
+func (g *GoPlug) forwardSignals() []os.Signal {
+	if g.ForwardSignals == nil {
+		return notIgnored(DefaultForwardSignals)
+	}
+	return notIgnored(g.ForwardSignals)
+}
+
+// notIgnored returns the signals which are not ignored by the host, e.g.
+// SIGHUP is ignored if the host was started by nohup. Listening for them
+// would stop ignoring them.
+func notIgnored(signals []os.Signal) []os.Signal {
+	var result []os.Signal
+	for _, sig := range signals {
+		if !signal.Ignored(sig) {
+			result = append(result, sig)
+		}
+	}
+	return result
+}
+
+// signalGracePeriod returns how long a plugin may take to exit after a
+// signal was forwarded to it.
+func (g *GoPlug) signalGracePeriod() time.Duration {
+	if g.SignalGracePeriod <= 0 {
+		return DefaultSignalGracePeriod
+	}
+	return g.SignalGracePeriod
+}
+
+// waitForwardingSignals waits until the OneShot plugin exited.
+// The signals received by the host meanwhile are forwarded to the plugin
+// and the processes it started instead of terminating the host. If the plugin does not exit within the
+// grace period afterwards, it gets killed. In both cases an
+// *InterruptedError is returned.
+//
+// Interactive plugins receive the signals of the terminal by themselves.
+// The host only keeps alive while they handle them, but they get killed
+// as well if they do not exit within the grace period.
+func (g *GoPlug) waitForwardingSignals(p *plugin, i *instance) error {
+	forward := g.forwardSignals()
+	if len(forward) == 0 && !p.isInteractive() {
+		return i.wait()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forward...)
+	if p.isInteractive() {
+		signal.Notify(signals, notIgnored(terminalSignals)...)
+	}
+	defer signal.Stop(signals)
+
+	var received os.Signal
+	var escalate <-chan time.Time
+	for {
+		select {
+		case <-i.done:
+			if received == nil {
+				return i.err
+			}
+			return checkpoint.From(&InterruptedError{
+				PluginID: p.ID,
+				Signal:   received,
+				Err:      i.err,
+			})
+		case sig := <-signals:
+			if received == nil {
+				escalate = time.After(g.signalGracePeriod())
+			}
+			received = sig
+
+			// The terminal already sent the signal to interactive plugins.
+			if p.isInteractive() && isTerminalSignal(sig) {
+				continue
+			}
+
+			err := i.signal(sig)
+			if err != nil {
+				log.Println(p.ID, "- could not forward", sig, "- killing it:", err)
+				escalate = time.After(0)
+			}
+		case <-escalate:
+			escalate = nil
+			err := i.kill()
+			if err != nil {
+				log.Println(p.ID, "- could not be killed:", err)
+			}
+		}
+	}
+}
+
+// isTerminalSignal checks if the signal is sent by the terminal to the
+// foreground process group.
+func isTerminalSignal(sig os.Signal) bool {
+	for _, s := range terminalSignals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
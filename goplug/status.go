@@ -0,0 +1,141 @@
+// File status.go contains the introspection of the loaded plugins.
+
+package goplug
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrUnsupportedPluginType = errors.New("plugin type is not supported")
+)
+
+// PluginState is the state of a plugin returned by GoPlug.Plugins.
+type PluginState string
+
+const (
+	// PluginDiscovered means that the plugin is registered and not running.
+	PluginDiscovered = PluginState("discovered")
+
+	// PluginRunning means that at least one process of the plugin runs.
+	PluginRunning = PluginState("running")
+
+	// PluginFailed means that the plugin could not be discovered or
+	// activated or its last process exited with an error.
+	PluginFailed = PluginState("failed")
+
+	// PluginDisabled means that the plugin was discovered but not
+	// registered, e.g. because it is incompatible or its dependencies
+	// are not satisfied.
+	PluginDisabled = PluginState("disabled")
+)
+
+// PluginStatus is a snapshot of a plugin.
+type PluginStatus struct {
+	// PluginInfo is empty if the plugin could not be discovered.
+	PluginInfo
+
+	// Path is the path of the plugin binary.
+	Path string
+
+	State PluginState
+
+	// LastError is the last error of the plugin. It is set if it could not
+	// be loaded or a process exited with an error.
+	LastError error
+
+	// StartedAt is the time the last process of the plugin was started.
+	// It is zero if the plugin was never started.
+	StartedAt time.Time
+
+	// Starts counts how often a process of the plugin was started.
+	Starts int
+
+	// Invocations counts the calls of a OneShot plugin or the messages
+	// handled by a background plugin.
+	Invocations int
+}
+
+// setInactive remembers a plugin file which could not be registered.
+// p is nil if the plugin information could not be read.
+func (g *GoPlug) setInactive(filePath string, p *plugin, state PluginState, err error) {
+	status := PluginStatus{
+		Path:      filePath,
+		State:     state,
+		LastError: err,
+	}
+	if p != nil {
+		status.PluginInfo = p.PluginInfo
+	}
+
+	g.inactiveMutex.Lock()
+	defer g.inactiveMutex.Unlock()
+	if g.inactive == nil {
+		g.inactive = make(map[string]PluginStatus)
+	}
+	g.inactive[filePath] = status
+}
+
+// clearInactive forgets the plugin file.
+func (g *GoPlug) clearInactive(filePath string) {
+	g.inactiveMutex.Lock()
+	defer g.inactiveMutex.Unlock()
+	delete(g.inactive, filePath)
+}
+
+// status returns a snapshot of the plugin.
+func (p *plugin) status() PluginStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := PluginStatus{
+		PluginInfo:  p.PluginInfo,
+		Path:        p.filePath,
+		State:       PluginDiscovered,
+		LastError:   p.lastErr,
+		StartedAt:   p.startedAt,
+		Starts:      p.starts,
+		Invocations: p.invocations,
+	}
+
+	if len(p.instances) > 0 {
+		status.State = PluginRunning
+	} else if p.crashed {
+		status.State = PluginFailed
+	}
+
+	return status
+}
+
+// countInvocation increases the invocation count of the plugin.
+func (p *plugin) countInvocation() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.invocations++
+}
+
+// Plugins returns a snapshot of all plugins found in the PluginFolder,
+// including the plugins which could not be loaded.
+// Registered plugins are sorted by their ID and come first, followed by
+// the other plugins sorted by their path.
+func (g *GoPlug) Plugins() []PluginStatus {
+	var plugins []PluginStatus
+	for _, p := range g.loadedPlugins() {
+		plugins = append(plugins, p.status())
+	}
+
+	g.inactiveMutex.Lock()
+	var inactive []PluginStatus
+	for _, status := range g.inactive {
+		inactive = append(inactive, status)
+	}
+	g.inactiveMutex.Unlock()
+
+	sort.Slice(inactive, func(i, j int) bool {
+		return inactive[i].Path < inactive[j].Path
+	})
+
+	return append(plugins, inactive...)
+}
@@ -0,0 +1,320 @@
+// File storage.go contains the persistent key/value storage which is
+// available to all plugins. Each plugin has its own namespace, so plugins
+// cannot read or modify the values of other plugins.
+
+package goplug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aligator/checkpoint"
+	"github.com/spf13/afero"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// DefaultStorageFolder is the folder, relative to the PluginFolder, which is
+// used by the default storage if GoPlug.Storage is not set.
+const DefaultStorageFolder = ".goplug-storage"
+
+// Storage persists the values stored by plugins.
+// All methods get the ID of the plugin which should be used as namespace.
+// Implementations have to be safe for concurrent use.
+type Storage interface {
+	// Get returns the value of the key.
+	// If it does not exist, ErrKeyNotFound is returned.
+	Get(pluginID string, key string) ([]byte, error)
+
+	// Set sets the value of the key.
+	Set(pluginID string, key string, value []byte) error
+
+	// Delete removes the key. It does nothing if the key does not exist.
+	Delete(pluginID string, key string) error
+
+	// List returns all keys which start with the prefix, sorted.
+	List(pluginID string, prefix string) ([]string, error)
+}
+
+// FileStorage is a Storage which saves the values of each plugin as json
+// file into a folder.
+type FileStorage struct {
+	FS afero.Fs
+
+	// Folder is the folder where the files get saved.
+	// It is created if it does not exist.
+	Folder string
+
+	mutex sync.Mutex
+}
+
+// NewFileStorage creates a FileStorage which saves the files into the folder.
+func NewFileStorage(fs afero.Fs, folder string) *FileStorage {
+	return &FileStorage{
+		FS:     fs,
+		Folder: folder,
+	}
+}
+
+func (s *FileStorage) path(pluginID string) string {
+	return filepath.Join(s.Folder, url.PathEscape(pluginID)+".json")
+}
+
+// load reads all values of the plugin.
+// The mutex has to be locked.
+func (s *FileStorage) load(pluginID string) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+
+	data, err := afero.ReadFile(s.FS, s.path(pluginID))
+	if errors.Is(err, afero.ErrFileNotFound) {
+		return values, nil
+	} else if err != nil {
+		return nil, checkpoint.From(err)
+	}
+
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
+	return values, nil
+}
+
+// save writes all values of the plugin.
+// The file is replaced atomically, so it does not get corrupted if the
+// host crashes while writing.
+// The mutex has to be locked.
+func (s *FileStorage) save(pluginID string, values map[string][]byte) error {
+	err := s.FS.MkdirAll(s.Folder, 0777)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	tmp := s.path(pluginID) + ".tmp"
+	err = afero.WriteFile(s.FS, tmp, data, 0666)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	return checkpoint.From(s.FS.Rename(tmp, s.path(pluginID)))
+}
+
+func (s *FileStorage) Get(pluginID string, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values, err := s.load(pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := values[key]
+	if !ok {
+		return nil, checkpoint.From(fmt.Errorf("key %v: %w", key, ErrKeyNotFound))
+	}
+	return value, nil
+}
+
+func (s *FileStorage) Set(pluginID string, key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values, err := s.load(pluginID)
+	if err != nil {
+		return err
+	}
+
+	values[key] = value
+	return s.save(pluginID, values)
+}
+
+func (s *FileStorage) Delete(pluginID string, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values, err := s.load(pluginID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := values[key]; !ok {
+		return nil
+	}
+
+	delete(values, key)
+	return s.save(pluginID, values)
+}
+
+func (s *FileStorage) List(pluginID string, prefix string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values, err := s.load(pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// storage returns the Storage of GoPlug.
+// If none is set, the default FileStorage is created.
+func (g *GoPlug) storage() Storage {
+	g.storageOnce.Do(func() {
+		if g.Storage == nil {
+			g.Storage = NewFileStorage(afero.NewOsFs(), filepath.Join(g.PluginFolder, DefaultStorageFolder))
+		}
+	})
+	return g.Storage
+}
+
+type StorageGetRequest struct {
+	Key string
+}
+
+type StorageGetResponse struct {
+	Value []byte
+	Found bool
+}
+
+// StorageGet returns the value of the key from the storage of the plugin.
+func (h *HostControl) StorageGet(args StorageGetRequest, reply *StorageGetResponse) error {
+	if h.plugin == nil || h.GoPlug == nil {
+		return checkpoint.From(ErrPluginDoesNotExist)
+	}
+
+	value, err := h.GoPlug.storage().Get(h.plugin.ID, args.Key)
+	if errors.Is(err, ErrKeyNotFound) {
+		*reply = StorageGetResponse{
+			Found: false,
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	*reply = StorageGetResponse{
+		Value: value,
+		Found: true,
+	}
+	return nil
+}
+
+type StorageSetRequest struct {
+	Key   string
+	Value []byte
+}
+
+type StorageSetResponse struct{}
+
+// StorageSet sets the value of the key in the storage of the plugin.
+func (h *HostControl) StorageSet(args StorageSetRequest, reply *StorageSetResponse) error {
+	if h.plugin == nil || h.GoPlug == nil {
+		return checkpoint.From(ErrPluginDoesNotExist)
+	}
+
+	return h.GoPlug.storage().Set(h.plugin.ID, args.Key, args.Value)
+}
+
+type StorageDeleteRequest struct {
+	Key string
+}
+
+type StorageDeleteResponse struct{}
+
+// StorageDelete removes the key from the storage of the plugin.
+func (h *HostControl) StorageDelete(args StorageDeleteRequest, reply *StorageDeleteResponse) error {
+	if h.plugin == nil || h.GoPlug == nil {
+		return checkpoint.From(ErrPluginDoesNotExist)
+	}
+
+	return h.GoPlug.storage().Delete(h.plugin.ID, args.Key)
+}
+
+type StorageListRequest struct {
+	Prefix string
+}
+
+type StorageListResponse struct {
+	Keys []string
+}
+
+// StorageList returns all keys of the storage of the plugin which start
+// with the prefix.
+func (h *HostControl) StorageList(args StorageListRequest, reply *StorageListResponse) error {
+	if h.plugin == nil || h.GoPlug == nil {
+		return checkpoint.From(ErrPluginDoesNotExist)
+	}
+
+	keys, err := h.GoPlug.storage().List(h.plugin.ID, args.Prefix)
+	if err != nil {
+		return err
+	}
+
+	*reply = StorageListResponse{
+		Keys: keys,
+	}
+	return nil
+}
+
+// StorageGet returns the value of the key from the storage of the plugin.
+// If the key does not exist, ErrKeyNotFound is returned.
+func (c *Client) StorageGet(key string) ([]byte, error) {
+	response := StorageGetResponse{}
+	err := c.client.Call("HostControl.StorageGet", StorageGetRequest{
+		Key: key,
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Found {
+		return nil, checkpoint.From(fmt.Errorf("key %v: %w", key, ErrKeyNotFound))
+	}
+	return response.Value, nil
+}
+
+// StorageSet sets the value of the key in the storage of the plugin.
+// It is persisted by the host, so it is still available in the next run.
+func (c *Client) StorageSet(key string, value []byte) error {
+	return c.client.Call("HostControl.StorageSet", StorageSetRequest{
+		Key:   key,
+		Value: value,
+	}, &StorageSetResponse{})
+}
+
+// StorageDelete removes the key from the storage of the plugin.
+func (c *Client) StorageDelete(key string) error {
+	return c.client.Call("HostControl.StorageDelete", StorageDeleteRequest{
+		Key: key,
+	}, &StorageDeleteResponse{})
+}
+
+// StorageList returns all keys of the storage of the plugin which start
+// with the prefix.
+func (c *Client) StorageList(prefix string) ([]string, error) {
+	response := StorageListResponse{}
+	err := c.client.Call("HostControl.StorageList", StorageListRequest{
+		Prefix: prefix,
+	}, &response)
+	return response.Keys, err
+}
@@ -0,0 +1,154 @@
+package goplug
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFileStorage(t *testing.T) {
+	s := NewFileStorage(afero.NewMemMapFs(), "storage")
+
+	_, err := s.Get("plugin", "missing")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get() of a missing key error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	err = s.Set("plugin", "key", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get("plugin", "key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "value")
+	}
+
+	// Each plugin has its own namespace.
+	_, err = s.Get("other", "key")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get() of another plugin error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	err = s.Set("plugin", "key", []byte("changed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = s.Get("plugin", "key")
+	if err != nil || string(got) != "changed" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "changed")
+	}
+
+	err = s.Delete("plugin", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Get("plugin", "key")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get() of a deleted key error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	// Deleting a missing key does nothing.
+	err = s.Delete("plugin", "key")
+	if err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+}
+
+func TestFileStoragePersists(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	err := NewFileStorage(fs, "storage").Set("plugin", "key", []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewFileStorage(fs, "storage").Get("plugin", "key")
+	if err != nil || string(got) != "value" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "value")
+	}
+}
+
+func TestFileStorageList(t *testing.T) {
+	s := NewFileStorage(afero.NewMemMapFs(), "storage")
+
+	keys, err := s.List("plugin", "")
+	if err != nil || len(keys) != 0 {
+		t.Errorf("List() without values = %v, %v, want []", keys, err)
+	}
+
+	for _, key := range []string{"b/2", "a", "b/1", "c"} {
+		err := s.Set("plugin", key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a", "b/1", "b/2", "c"}},
+		{prefix: "b/", want: []string{"b/1", "b/2"}},
+		{prefix: "d", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got, err := s.List("plugin", tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStoragePathEscape(t *testing.T) {
+	tests := []string{
+		"../escape",
+		"..",
+		"github.com/aligator/plugin@v1",
+		"/absolute",
+	}
+
+	folder := filepath.Join(string(filepath.Separator), "plugins", "storage")
+	for _, pluginID := range tests {
+		t.Run(pluginID, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			s := NewFileStorage(fs, folder)
+
+			err := s.Set(pluginID, "key", []byte("value"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The plugin ID must not be able to leave the storage folder.
+			err = afero.Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				if filepath.Dir(path) != folder {
+					t.Errorf("file %v written outside of the storage folder", path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.Get(pluginID, "key")
+			if err != nil || string(got) != "value" {
+				t.Errorf("Get() = %q, %v, want %q", got, err, "value")
+			}
+		})
+	}
+}
@@ -0,0 +1,552 @@
+// File stream.go contains the side channel which is used to transfer binary
+// data (io.Reader, io.Writer and []byte) between the host and a plugin.
+// The data is sent in chunks over an extra pipe pair instead of the jsonrpc
+// codec, so it does not need to be buffered or base64 encoded.
+//
+// All streams of one plugin share the same side channel. Streams from the
+// plugin to the host are sent one after another in the order they were
+// opened. The receiver buffers the data of each stream, so a stream which
+// is not read does not block the other streams.
+
+package goplug
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrStreamDoesNotExist = errors.New("stream does not exist")
+	ErrStreamClosed       = errors.New("stream closed")
+)
+
+// StreamID identifies a stream on the side channel.
+// It is allocated by the host and unique across all plugins.
+type StreamID uint64
+
+const (
+	// streamInFd is the file descriptor the plugin receives stream data on.
+	streamInFd = 3
+	// streamOutFd is the file descriptor the plugin sends stream data to.
+	streamOutFd = 4
+
+	// streamChunkSize is the maximum size of the data of one frame.
+	streamChunkSize = 32 * 1024
+)
+
+// Frame kinds.
+const (
+	frameData byte = iota
+	frameEOF
+	frameError
+)
+
+// frameHeaderSize is the size of the header: 8 byte id, 1 byte kind and
+// 4 byte length of the following data.
+const frameHeaderSize = 8 + 1 + 4
+
+// streamMux multiplexes several streams over one pipe pair.
+type streamMux struct {
+	in  io.Reader
+	out io.Writer
+
+	// outMutex guards writing single frames to out.
+	outMutex sync.Mutex
+
+	// sendMutex makes sure that only one stream gets sent at a time.
+	sendMutex sync.Mutex
+
+	incomingMutex sync.Mutex
+	incoming      map[StreamID]*streamBuffer
+	closed        bool
+}
+
+func newStreamMux(in io.Reader, out io.Writer) *streamMux {
+	return &streamMux{
+		in:       in,
+		out:      out,
+		incoming: make(map[StreamID]*streamBuffer),
+	}
+}
+
+// receive registers a new incoming stream.
+// The returned reader should be closed, otherwise the received data stays
+// buffered until the side channel gets closed.
+func (m *streamMux) receive(id StreamID) io.ReadCloser {
+	b := newStreamBuffer()
+
+	m.incomingMutex.Lock()
+	defer m.incomingMutex.Unlock()
+	if m.closed {
+		b.end(ErrStreamClosed)
+	} else {
+		m.incoming[id] = b
+	}
+
+	return b
+}
+
+// serve reads all frames from the side channel and dispatches them to the
+// registered incoming streams. Data for unknown or closed streams is
+// discarded. It never waits for the readers of the streams.
+// It blocks until the side channel gets closed.
+func (m *streamMux) serve() error {
+	header := make([]byte, frameHeaderSize)
+	buf := make([]byte, streamChunkSize)
+
+	defer m.close()
+
+	for {
+		_, err := io.ReadFull(m.in, header)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return checkpoint.From(err)
+		}
+
+		id := StreamID(binary.BigEndian.Uint64(header[0:8]))
+		kind := header[8]
+		length := binary.BigEndian.Uint32(header[9:13])
+		if length > streamChunkSize {
+			return checkpoint.From(fmt.Errorf("frame too big: %v", length))
+		}
+
+		data := buf[:length]
+		_, err = io.ReadFull(m.in, data)
+		if err != nil {
+			return checkpoint.From(err)
+		}
+
+		m.incomingMutex.Lock()
+		b, ok := m.incoming[id]
+		if ok && kind != frameData {
+			delete(m.incoming, id)
+		}
+		m.incomingMutex.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		switch kind {
+		case frameData:
+			if !b.write(data) {
+				// The reader got closed, ignore the rest of the stream.
+				m.incomingMutex.Lock()
+				delete(m.incoming, id)
+				m.incomingMutex.Unlock()
+			}
+		case frameEOF:
+			b.end(nil)
+		case frameError:
+			b.end(errors.New(string(data)))
+		}
+	}
+}
+
+// close closes all incoming streams.
+func (m *streamMux) close() {
+	m.incomingMutex.Lock()
+	defer m.incomingMutex.Unlock()
+
+	m.closed = true
+	for id, b := range m.incoming {
+		b.end(ErrStreamClosed)
+		delete(m.incoming, id)
+	}
+}
+
+// streamBuffer buffers the data of an incoming stream until it is read.
+type streamBuffer struct {
+	mutex sync.Mutex
+
+	// changed is signaled when data arrives, the stream ends or the
+	// reader gets closed.
+	changed *sync.Cond
+
+	data bytes.Buffer
+
+	// err is set when the stream ended. It is io.EOF if the sender closed
+	// the stream without an error.
+	err error
+
+	// closed is true if the reader got closed.
+	closed bool
+}
+
+func newStreamBuffer() *streamBuffer {
+	b := &streamBuffer{}
+	b.changed = sync.NewCond(&b.mutex)
+	return b
+}
+
+// write appends the data to the buffer.
+// It returns false if the reader got closed.
+func (b *streamBuffer) write(data []byte) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return false
+	}
+
+	b.data.Write(data)
+	b.changed.Broadcast()
+	return true
+}
+
+// end marks the end of the stream. The reader receives err after all
+// buffered data, or io.EOF if err is nil.
+func (b *streamBuffer) end(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+	if b.err == nil {
+		b.err = err
+	}
+	b.changed.Broadcast()
+}
+
+func (b *streamBuffer) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for b.data.Len() == 0 && b.err == nil && !b.closed {
+		b.changed.Wait()
+	}
+
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if b.data.Len() > 0 {
+		return b.data.Read(p)
+	}
+	return 0, b.err
+}
+
+// Close discards the buffered data and all data which arrives later.
+func (b *streamBuffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	b.data = bytes.Buffer{}
+	b.changed.Broadcast()
+	return nil
+}
+
+func (m *streamMux) writeFrame(id StreamID, kind byte, data []byte) error {
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint64(header[0:8], uint64(id))
+	header[8] = kind
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(data)))
+
+	m.outMutex.Lock()
+	defer m.outMutex.Unlock()
+
+	_, err := m.out.Write(header)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	_, err = m.out.Write(data)
+	return checkpoint.From(err)
+}
+
+// writer returns a writer which sends everything written to it as the
+// stream with the given id. Close sends the end of the stream.
+func (m *streamMux) writer(id StreamID) *streamWriter {
+	return &streamWriter{
+		id:  id,
+		mux: m,
+	}
+}
+
+// send copies everything from r to the stream with the given id.
+// If reading fails, the error is sent to the receiver.
+func (m *streamMux) send(id StreamID, r io.Reader) error {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+
+	w := m.writer(id)
+	_, err := io.CopyBuffer(w, r, make([]byte, streamChunkSize))
+	if err != nil {
+		_ = w.CloseWithError(err)
+		return checkpoint.From(err)
+	}
+	return w.Close()
+}
+
+// streamWriter writes to a single stream of a streamMux.
+type streamWriter struct {
+	id     StreamID
+	mux    *streamMux
+	closed bool
+}
+
+func (w *streamWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, checkpoint.From(ErrStreamClosed)
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > streamChunkSize {
+			chunk = chunk[:streamChunkSize]
+		}
+
+		err = w.mux.writeFrame(w.id, frameData, chunk)
+		if err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// Close sends the end of the stream.
+func (w *streamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.mux.writeFrame(w.id, frameEOF, nil)
+}
+
+// CloseWithError ends the stream and passes the error to the receiver.
+func (w *streamWriter) CloseWithError(err error) error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	msg := []byte(err.Error())
+	if len(msg) > streamChunkSize {
+		msg = msg[:streamChunkSize]
+	}
+	return w.mux.writeFrame(w.id, frameError, msg)
+}
+
+// lastStreamID is used to allocate unique stream ids.
+// Ids start at 1, so 0 never identifies a stream.
+var lastStreamID uint64
+
+func nextStreamID() StreamID {
+	return StreamID(atomic.AddUint64(&lastStreamID, 1))
+}
+
+// StreamRegistry contains the streams of one plugin process which were not
+// yet claimed. The stream ids passed by a plugin are only resolved against
+// the registry of that plugin, so a plugin cannot access the streams of
+// another one.
+// Host actions get it through StreamBinder.
+type StreamRegistry struct {
+	mutex   sync.Mutex
+	entries map[StreamID]interface{}
+	closed  bool
+}
+
+// StreamBinder is implemented by host actions which use streams, like the
+// HostActions generated by the goplug generator.
+// GoPlug binds the actions to the StreamRegistry of each plugin process.
+type StreamBinder interface {
+	// BindStreams returns the actions which are registered for one plugin
+	// process. They have to use the given registry for all streams.
+	BindStreams(streams *StreamRegistry) interface{}
+}
+
+// servedStream is a stream served by the host which is sent as soon as
+// the plugin pulls it.
+type servedStream struct {
+	r io.Reader
+}
+
+func newStreamRegistry() *StreamRegistry {
+	return &StreamRegistry{
+		entries: make(map[StreamID]interface{}),
+	}
+}
+
+// add registers the stream. If the registry is already closed, the stream
+// gets closed immediately.
+func (s *StreamRegistry) add(id StreamID, entry interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		closeStreamEntry(entry)
+		return
+	}
+	s.entries[id] = entry
+}
+
+func (s *StreamRegistry) claim(id StreamID) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	delete(s.entries, id)
+	return entry, ok
+}
+
+// close closes all streams which were not claimed.
+// It is called when the plugin process exited.
+func (s *StreamRegistry) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	for id, entry := range s.entries {
+		closeStreamEntry(entry)
+		delete(s.entries, id)
+	}
+}
+
+// closeStreamEntry closes a stream of the registry.
+func closeStreamEntry(entry interface{}) {
+	if served, ok := entry.(servedStream); ok {
+		entry = served.r
+	}
+
+	if closer, ok := entry.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// Reader returns the reader of a stream sent by the plugin.
+// It is used by the generated host actions for io.Reader parameters.
+// The reader has to be closed after usage.
+func (s *StreamRegistry) Reader(id StreamID) (io.ReadCloser, error) {
+	entry, ok := s.claim(id)
+	if !ok {
+		return nil, checkpoint.From(fmt.Errorf("StreamID: %v: %w", id, ErrStreamDoesNotExist))
+	}
+
+	r, ok := entry.(io.ReadCloser)
+	if !ok {
+		closeStreamEntry(entry)
+		return nil, checkpoint.From(fmt.Errorf("StreamID: %v: not readable: %w", id, ErrStreamDoesNotExist))
+	}
+
+	return r, nil
+}
+
+// ReadAll reads the whole stream sent by the plugin.
+// It is used by the generated host actions for []byte parameters.
+func (s *StreamRegistry) ReadAll(id StreamID) ([]byte, error) {
+	r, err := s.Reader(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, checkpoint.From(err)
+	}
+	return data, nil
+}
+
+// Writer returns a writer which sends the data to the plugin.
+// It is used by the generated host actions for io.Writer parameters.
+// The writer has to be closed after usage.
+func (s *StreamRegistry) Writer(id StreamID) (io.WriteCloser, error) {
+	entry, ok := s.claim(id)
+	if !ok {
+		return nil, checkpoint.From(fmt.Errorf("StreamID: %v: %w", id, ErrStreamDoesNotExist))
+	}
+
+	w, ok := entry.(io.WriteCloser)
+	if !ok {
+		closeStreamEntry(entry)
+		return nil, checkpoint.From(fmt.Errorf("StreamID: %v: not writable: %w", id, ErrStreamDoesNotExist))
+	}
+
+	return w, nil
+}
+
+// Serve makes the reader available to the plugin which can pull it by the
+// returned id. It is used by the generated host actions for io.Reader results.
+// If r is an io.Closer, it gets closed after it was sent or when the
+// plugin exits without pulling it.
+// Without a registry, r is closed and the returned id does not exist.
+func (s *StreamRegistry) Serve(r io.Reader) StreamID {
+	if s == nil {
+		closeStreamEntry(r)
+		return 0
+	}
+
+	id := nextStreamID()
+	s.add(id, servedStream{r: r})
+	return id
+}
+
+// ServeBytes makes the data available to the plugin which can pull it by the
+// returned id. It is used by the generated host actions for []byte results.
+func (s *StreamRegistry) ServeBytes(data []byte) StreamID {
+	return s.Serve(bytes.NewReader(data))
+}
+
+// Transfer is a stream transfer started by a plugin.
+type Transfer struct {
+	// ID has to be passed to the host action.
+	ID StreamID
+
+	done  chan struct{}
+	err   error
+	abort func()
+}
+
+// Wait blocks until the transfer is done.
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Close aborts the transfer if it is not done yet.
+func (t *Transfer) Close() error {
+	select {
+	case <-t.done:
+	default:
+		t.abort()
+	}
+	return nil
+}
+
+// newStreamMuxFromFds creates the plugin side of the side channel.
+func newStreamMuxFromFds() *streamMux {
+	return newStreamMux(
+		os.NewFile(streamInFd, "goplug-stream-in"),
+		os.NewFile(streamOutFd, "goplug-stream-out"),
+	)
+}
+
+// abortableReader stops reading as soon as it gets aborted.
+type abortableReader struct {
+	r       io.Reader
+	aborted int32
+}
+
+func (a *abortableReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&a.aborted) == 1 {
+		return 0, ErrStreamClosed
+	}
+	return a.r.Read(p)
+}
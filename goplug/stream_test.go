@@ -0,0 +1,339 @@
+package goplug
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// newTestMuxPair connects a sending and a receiving streamMux by an io.Pipe.
+// The receiver is served until the returned function gets called.
+func newTestMuxPair(t *testing.T) (*streamMux, *streamMux, func()) {
+	t.Helper()
+
+	r, w := io.Pipe()
+	sender := newStreamMux(nil, w)
+	receiver := newStreamMux(r, nil)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- receiver.serve()
+	}()
+
+	return sender, receiver, func() {
+		_ = w.Close()
+		if err := <-served; err != nil {
+			t.Errorf("serve() error = %v", err)
+		}
+	}
+}
+
+// readWithTimeout reads the whole stream and fails if it takes too long.
+func readWithTimeout(t *testing.T, r io.Reader) ([]byte, error) {
+	t.Helper()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := ioutil.ReadAll(r)
+		done <- result{data, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.data, res.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading the stream timed out")
+		return nil, nil
+	}
+}
+
+func TestStreamWriterChunks(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		wantFrames int
+	}{
+		{name: "empty", size: 0, wantFrames: 0},
+		{name: "one byte", size: 1, wantFrames: 1},
+		{name: "one chunk", size: streamChunkSize, wantFrames: 1},
+		{name: "several chunks", size: 3*streamChunkSize + 1, wantFrames: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			m := newStreamMux(nil, out)
+
+			err := m.send(7, bytes.NewReader(make([]byte, tt.size)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var frames, total int
+			var last byte
+			for out.Len() > 0 {
+				header := out.Next(frameHeaderSize)
+				if id := binary.BigEndian.Uint64(header[0:8]); id != 7 {
+					t.Fatalf("frame id = %v, want 7", id)
+				}
+				length := int(binary.BigEndian.Uint32(header[9:13]))
+				if length > streamChunkSize {
+					t.Fatalf("frame length = %v, want at most %v", length, streamChunkSize)
+				}
+				out.Next(length)
+
+				last = header[8]
+				if last == frameData {
+					frames++
+					total += length
+				}
+			}
+
+			if frames != tt.wantFrames {
+				t.Errorf("data frames = %v, want %v", frames, tt.wantFrames)
+			}
+			if total != tt.size {
+				t.Errorf("sent bytes = %v, want %v", total, tt.size)
+			}
+			if last != frameEOF {
+				t.Errorf("last frame kind = %v, want %v", last, frameEOF)
+			}
+		})
+	}
+}
+
+func TestStreamMuxTransfer(t *testing.T) {
+	data := bytes.Repeat([]byte("goplug"), streamChunkSize)
+
+	tests := []struct {
+		name     string
+		send     func(m *streamMux, id StreamID) error
+		wantData []byte
+		wantErr  string
+	}{
+		{
+			name: "eof",
+			send: func(m *streamMux, id StreamID) error {
+				return m.send(id, bytes.NewReader(data))
+			},
+			wantData: data,
+		},
+		{
+			name: "error",
+			send: func(m *streamMux, id StreamID) error {
+				w := m.writer(id)
+				_, err := w.Write([]byte("partial"))
+				if err != nil {
+					return err
+				}
+				return w.CloseWithError(errors.New("reading failed"))
+			},
+			wantData: []byte("partial"),
+			wantErr:  "reading failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, receiver, stop := newTestMuxPair(t)
+			defer stop()
+
+			id := nextStreamID()
+			r := receiver.receive(id)
+			defer r.Close()
+
+			err := tt.send(sender, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readWithTimeout(t, r)
+			if !bytes.Equal(got, tt.wantData) {
+				t.Errorf("received %v bytes, want %v", len(got), len(tt.wantData))
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamMuxUnknownStream(t *testing.T) {
+	sender, receiver, stop := newTestMuxPair(t)
+	defer stop()
+
+	known := nextStreamID()
+	r := receiver.receive(known)
+	defer r.Close()
+
+	// Data for streams nobody waits for is discarded.
+	err := sender.send(nextStreamID(), bytes.NewReader([]byte("unknown")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sender.send(known, bytes.NewReader([]byte("known")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readWithTimeout(t, r)
+	if err != nil || string(got) != "known" {
+		t.Errorf("received %q, %v, want %q", got, err, "known")
+	}
+}
+
+func TestStreamMuxUnreadStreamDoesNotBlock(t *testing.T) {
+	sender, receiver, stop := newTestMuxPair(t)
+	defer stop()
+
+	unread := nextStreamID()
+	u := receiver.receive(unread)
+	defer u.Close()
+
+	read := nextStreamID()
+	r := receiver.receive(read)
+	defer r.Close()
+
+	// The pipe does not buffer anything, so sending blocks until serve
+	// handled all frames of the unread stream.
+	sent := make(chan error, 1)
+	go func() {
+		err := sender.send(unread, bytes.NewReader(make([]byte, 10*streamChunkSize)))
+		if err == nil {
+			err = sender.send(read, bytes.NewReader([]byte("data")))
+		}
+		sent <- err
+	}()
+
+	got, err := readWithTimeout(t, r)
+	if err != nil || string(got) != "data" {
+		t.Errorf("received %q, %v, want %q", got, err, "data")
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = readWithTimeout(t, u)
+	if err != nil || len(got) != 10*streamChunkSize {
+		t.Errorf("received %v bytes, %v, want %v", len(got), err, 10*streamChunkSize)
+	}
+}
+
+func TestStreamMuxClose(t *testing.T) {
+	sender, receiver, stop := newTestMuxPair(t)
+
+	id := nextStreamID()
+	r := receiver.receive(id)
+	defer r.Close()
+
+	_, err := sender.writer(id).Write([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the side channel ends the streams which did not end yet.
+	stop()
+
+	got, err := readWithTimeout(t, r)
+	if string(got) != "data" || !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("received %q, %v, want %q, %v", got, err, "data", ErrStreamClosed)
+	}
+
+	// Streams registered after the side channel was closed end immediately.
+	_, err = readWithTimeout(t, receiver.receive(nextStreamID()))
+	if !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("error = %v, want %v", err, ErrStreamClosed)
+	}
+}
+
+func TestStreamBufferClose(t *testing.T) {
+	b := newStreamBuffer()
+	if !b.write([]byte("data")) {
+		t.Fatal("write() = false before the reader was closed")
+	}
+
+	_ = b.Close()
+	if b.write([]byte("more")) {
+		t.Error("write() = true after the reader was closed")
+	}
+
+	_, err := b.Read(make([]byte, 10))
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Read() error = %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+// testCloser records if it was closed.
+type testCloser struct {
+	io.Reader
+	closed bool
+}
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestStreamRegistryIsolation(t *testing.T) {
+	first := newStreamRegistry()
+	second := newStreamRegistry()
+
+	id := first.ServeBytes([]byte("data"))
+
+	_, err := second.Reader(id)
+	if !errors.Is(err, ErrStreamDoesNotExist) {
+		t.Errorf("Reader() of another registry error = %v, want %v", err, ErrStreamDoesNotExist)
+	}
+
+	entry, ok := first.claim(id)
+	if !ok {
+		t.Fatal("the stream is not in its registry")
+	}
+	if _, ok := entry.(servedStream); !ok {
+		t.Errorf("entry = %T, want servedStream", entry)
+	}
+
+	// A stream can only be claimed once.
+	if _, ok := first.claim(id); ok {
+		t.Error("the stream was claimed twice")
+	}
+}
+
+func TestStreamRegistryClose(t *testing.T) {
+	s := newStreamRegistry()
+
+	unclaimed := &testCloser{Reader: bytes.NewReader(nil)}
+	s.Serve(unclaimed)
+
+	s.close()
+	if !unclaimed.closed {
+		t.Error("the unclaimed stream was not closed")
+	}
+
+	late := &testCloser{Reader: bytes.NewReader(nil)}
+	id := s.Serve(late)
+	if !late.closed {
+		t.Error("the stream served after close was not closed")
+	}
+	if _, err := s.Reader(id); !errors.Is(err, ErrStreamDoesNotExist) {
+		t.Errorf("Reader() error = %v, want %v", err, ErrStreamDoesNotExist)
+	}
+
+	var nilRegistry *StreamRegistry
+	closed := &testCloser{Reader: bytes.NewReader(nil)}
+	if id := nilRegistry.Serve(closed); id != 0 || !closed.closed {
+		t.Errorf("Serve() without registry = %v, closed %v, want 0, true", id, closed.closed)
+	}
+}
@@ -0,0 +1,208 @@
+// File version.go contains the compatibility checks between the host API
+// and the plugins. The generator stamps the version of the host API and a
+// hash of all actions into the generated actions package. Plugins send them
+// together with a range of compatible host API versions.
+
+package goplug
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aligator/checkpoint"
+	"golang.org/x/mod/semver"
+)
+
+var (
+	ErrInvalidVersion     = errors.New("invalid version")
+	ErrIncompatiblePlugin = errors.New("plugin is incompatible with the host API")
+)
+
+// Version is the version of goplug.
+// Plugins can require a minimum version using PluginInfo.MinGoPlugVersion.
+const Version = "0.1.0"
+
+// APIInfo describes the actions provided by the host.
+type APIInfo struct {
+	// Version is the semver of the host API.
+	Version string
+
+	// ActionsHash is a hash of the signatures of all actions.
+	ActionsHash string
+}
+
+// apiInfoProvider is implemented by the HostActions generated by the
+// goplug generator.
+type apiInfoProvider interface {
+	APIInfo() APIInfo
+}
+
+// CompatibilityPolicy defines what happens with a plugin which is not
+// compatible with the host API.
+type CompatibilityPolicy int
+
+const (
+	// RefuseIncompatible does not register incompatible plugins.
+	// Init returns ErrIncompatiblePlugin for them.
+	RefuseIncompatible CompatibilityPolicy = iota
+
+	// WarnIncompatible logs a warning but still registers the plugin.
+	WarnIncompatible
+)
+
+// canonicalVersion converts the version to the format used by the semver
+// package, which requires a leading "v".
+func canonicalVersion(version string) (string, error) {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	if !semver.IsValid(version) {
+		return "", checkpoint.From(fmt.Errorf("%v: %w", version, ErrInvalidVersion))
+	}
+	return version, nil
+}
+
+// VersionMatches checks if the version is inside of the range.
+// The range consists of constraints separated by spaces or commas which all
+// have to match. Supported constraints are:
+//   - "1.2.3", "=1.2.3": exactly this version
+//   - ">1.2.3", ">=1.2.3", "<1.2.3", "<=1.2.3": comparisons
+//   - "^1.2.3": at least 1.2.3 with the same major version
+//     (the same minor version for 0.x versions)
+//   - "~1.2.3": at least 1.2.3 with the same major and minor version
+//
+// An empty range matches all versions.
+func VersionMatches(version string, versionRange string) (bool, error) {
+	version, err := canonicalVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	constraints := strings.FieldsFunc(versionRange, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	for _, constraint := range constraints {
+		// The operator is the prefix before the version.
+		split := strings.IndexFunc(constraint, func(r rune) bool {
+			return !strings.ContainsRune("<>=^~", r)
+		})
+		if split < 0 {
+			return false, checkpoint.From(fmt.Errorf("constraint %v: %w", constraint, ErrInvalidVersion))
+		}
+
+		operator := constraint[:split]
+		bound, err := canonicalVersion(constraint[split:])
+		if err != nil {
+			return false, err
+		}
+
+		cmp := semver.Compare(version, bound)
+		var ok bool
+		switch operator {
+		case "", "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case "^":
+			if semver.Major(bound) == "v0" {
+				ok = cmp >= 0 && semver.MajorMinor(version) == semver.MajorMinor(bound)
+			} else {
+				ok = cmp >= 0 && semver.Major(version) == semver.Major(bound)
+			}
+		case "~":
+			ok = cmp >= 0 && semver.MajorMinor(version) == semver.MajorMinor(bound)
+		default:
+			return false, checkpoint.From(fmt.Errorf("constraint %v: %w", constraint, ErrInvalidVersion))
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// checkCompatibility checks if the plugin can be used with the host API.
+// If the host API is unknown, because the Actions are not generated by
+// goplug, only the goplug version is checked.
+func (g *GoPlug) checkCompatibility(p *plugin) error {
+	if p.Version != "" {
+		_, err := canonicalVersion(p.Version)
+		if err != nil {
+			return checkpoint.From(fmt.Errorf("PluginID: %v: %w", p.ID, err))
+		}
+	}
+
+	if p.MinGoPlugVersion != "" {
+		matches, err := VersionMatches(Version, ">="+p.MinGoPlugVersion)
+		if err != nil {
+			return checkpoint.From(fmt.Errorf("PluginID: %v: %w", p.ID, err))
+		}
+
+		if !matches {
+			err = g.refuseIncompatible(fmt.Errorf("PluginID: %v: requires goplug %v but the host uses %v", p.ID, p.MinGoPlugVersion, Version))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	provider, ok := g.Actions.(apiInfoProvider)
+	if !ok {
+		return nil
+	}
+	api := provider.APIInfo()
+
+	// If the plugin does not declare a range, it is compatible with all
+	// later versions of the host API with the same major version.
+	versionRange := p.HostAPI
+	if versionRange == "" && p.HostAPIVersion != "" {
+		versionRange = "^" + p.HostAPIVersion
+	}
+
+	matches, err := VersionMatches(api.Version, versionRange)
+	if err != nil {
+		return checkpoint.From(fmt.Errorf("PluginID: %v: %w", p.ID, err))
+	}
+
+	var incompatible error
+	if !matches {
+		incompatible = fmt.Errorf("PluginID: %v: host API %v does not match %v", p.ID, api.Version, versionRange)
+	} else if p.ActionsHash != "" && p.ActionsHash != api.ActionsHash {
+		if p.HostAPIVersion == api.Version {
+			// The actions changed without changing the version.
+			incompatible = fmt.Errorf("PluginID: %v: actions of host API %v differ (%v != %v)", p.ID, api.Version, p.ActionsHash, api.ActionsHash)
+		} else {
+			// The version matches, so the actions should have changed in a
+			// compatible way, e.g. new actions were added.
+			log.Println(p.ID, "- was built against host API", p.HostAPIVersion, "but the host provides", api.Version)
+		}
+	}
+
+	return g.refuseIncompatible(incompatible)
+}
+
+// refuseIncompatible returns the error of an incompatible plugin as
+// ErrIncompatiblePlugin, depending on the CompatibilityPolicy.
+func (g *GoPlug) refuseIncompatible(incompatible error) error {
+	if incompatible == nil {
+		return nil
+	}
+
+	if g.CompatibilityPolicy == WarnIncompatible {
+		log.Println(incompatible)
+		return nil
+	}
+	return checkpoint.Wrap(incompatible, ErrIncompatiblePlugin)
+}
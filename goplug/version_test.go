@@ -0,0 +1,55 @@
+package goplug
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVersionMatches(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      string
+		versionRange string
+		want         bool
+		wantErr      error
+	}{
+		{name: "empty range", version: "1.2.3", versionRange: "", want: true},
+		{name: "exact", version: "1.2.3", versionRange: "1.2.3", want: true},
+		{name: "exact with v", version: "v1.2.3", versionRange: "=1.2.3", want: true},
+		{name: "exact mismatch", version: "1.2.4", versionRange: "1.2.3", want: false},
+		{name: "greater", version: "1.3.0", versionRange: ">1.2.3", want: true},
+		{name: "greater equal", version: "1.2.3", versionRange: ">=1.2.3", want: true},
+		{name: "less", version: "1.2.3", versionRange: "<1.2.3", want: false},
+		{name: "less equal", version: "1.2.3", versionRange: "<=1.2.3", want: true},
+		{name: "caret", version: "1.9.0", versionRange: "^1.2.3", want: true},
+		{name: "caret next major", version: "2.0.0", versionRange: "^1.2.3", want: false},
+		{name: "caret 0.x", version: "0.3.0", versionRange: "^0.2.1", want: false},
+		{name: "caret 0.x same minor", version: "0.2.5", versionRange: "^0.2.1", want: true},
+		{name: "tilde", version: "1.2.9", versionRange: "~1.2.3", want: true},
+		{name: "tilde next minor", version: "1.3.0", versionRange: "~1.2.3", want: false},
+		{name: "all constraints", version: "1.5.0", versionRange: ">=1.2.0, <2.0.0", want: true},
+		{name: "one constraint fails", version: "2.1.0", versionRange: ">=1.2.0 <2.0.0", want: false},
+		{name: "invalid version", version: "abc", versionRange: "1.2.3", wantErr: ErrInvalidVersion},
+		{name: "invalid bound", version: "1.2.3", versionRange: ">=x", wantErr: ErrInvalidVersion},
+		{name: "operator only", version: "1.2.3", versionRange: ">=", wantErr: ErrInvalidVersion},
+		{name: "invalid operator", version: "1.2.3", versionRange: "=>1.2.3", wantErr: ErrInvalidVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VersionMatches(tt.version, tt.versionRange)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("VersionMatches(%q, %q) error = %v, want %v", tt.version, tt.versionRange, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VersionMatches(%q, %q) error = %v", tt.version, tt.versionRange, err)
+			}
+			if got != tt.want {
+				t.Errorf("VersionMatches(%q, %q) = %v, want %v", tt.version, tt.versionRange, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+// File watch.go contains the hot reload of plugins. The PluginFolder is
+// polled for added, removed and changed plugin binaries.
+
+package goplug
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/aligator/checkpoint"
+)
+
+var (
+	ErrNotInitialized = errors.New("GoPlug is not initialized")
+)
+
+// DefaultWatchInterval is used if GoPlug.WatchInterval is not set.
+const DefaultWatchInterval = 2 * time.Second
+
+// fileState is used to detect changes of a plugin file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotFiles returns the state of all valid plugin files.
+func (g *GoPlug) snapshotFiles(entries []fs.FileInfo) map[string]fileState {
+	files := make(map[string]fileState)
+	for _, entry := range entries {
+		if !g.isValidPlugin(entry) {
+			continue
+		}
+
+		files[path.Join(g.PluginFolder, entry.Name())] = fileState{
+			modTime: entry.ModTime(),
+			size:    entry.Size(),
+		}
+	}
+	return files
+}
+
+// Watch polls the PluginFolder for added, removed or changed plugins
+// until the context is done or GoPlug is shut down.
+// It has to be called after Init.
+//
+// Added and changed plugins are discovered again and registered at the
+// host. If the Host implements ReloadHost, it gets notified about removed
+// and replaced OneShot plugins.
+// If a changed plugin cannot be discovered, e.g. because its binary is
+// still being written, the loaded version is kept and the discovery is
+// retried with the next scan.
+// Plugins whose dependencies are removed get unloaded as well. They are
+// registered again as soon as their dependencies are available.
+// Running instances of removed or replaced plugins are not killed.
+// OneShot plugins run until they are done and Listener plugins get stopped
+// after they received all queued messages.
+//
+// Errors while reloading plugins are logged.
+func (g *GoPlug) Watch(ctx context.Context) error {
+	g.filesMutex.Lock()
+	initialized := g.files != nil
+	g.filesMutex.Unlock()
+	if !initialized {
+		return checkpoint.From(ErrNotInitialized)
+	}
+
+	interval := g.WatchInterval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.shutdownChan():
+			return nil
+		case <-ticker.C:
+			err := g.rescan()
+			if err != nil {
+				log.Println("could not reload the plugins:", err)
+			}
+		}
+	}
+}
+
+// rescan reloads all plugins which changed since the last scan.
+func (g *GoPlug) rescan() error {
+	g.registerMutex.Lock()
+	defer g.registerMutex.Unlock()
+
+	entries, err := ioutil.ReadDir(g.PluginFolder)
+	if err != nil {
+		return checkpoint.From(err)
+	}
+
+	files := g.snapshotFiles(entries)
+
+	g.filesMutex.Lock()
+	lastFiles := g.files
+	g.filesMutex.Unlock()
+
+	var changed, removed []string
+	for filePath, state := range files {
+		if last, ok := lastFiles[filePath]; !ok || last != state {
+			changed = append(changed, filePath)
+		}
+	}
+	for filePath := range lastFiles {
+		if _, ok := files[filePath]; !ok {
+			removed = append(removed, filePath)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+
+	for _, filePath := range removed {
+		g.clearInactive(filePath)
+		delete(g.waiting, filePath)
+		if p := g.pluginByPath(filePath); p != nil {
+			g.logError(g.unload(p, ReasonRemoved))
+		}
+	}
+
+	// Discover the new and changed plugins.
+	var discovered []*plugin
+	replaces := make(map[*plugin]*plugin)
+	for _, filePath := range changed {
+		delete(g.waiting, filePath)
+		old := g.pluginByPath(filePath)
+
+		p, err := g.load(filePath)
+		if err != nil && old != nil {
+			// The binary may still be written. Keep the old plugin and
+			// try again with the next scan.
+			log.Println(filePath, "- keeping the loaded plugin:", err)
+			files[filePath] = lastFiles[filePath]
+			continue
+		} else if err != nil {
+			log.Println(g.setDiscoveryFailed(filePath, p, err))
+			continue
+		}
+
+		discovered = append(discovered, p)
+		if old != nil {
+			replaces[p] = old
+		}
+	}
+
+	// Remember the state of the files. Failed replacements keep their old
+	// state, so they are discovered again.
+	g.filesMutex.Lock()
+	g.files = files
+	g.filesMutex.Unlock()
+
+	if len(changed) > 0 || len(removed) > 0 {
+		g.logError(g.saveCache())
+	}
+
+	if len(discovered) > 0 {
+		// The new plugins may satisfy the dependencies of waiting plugins.
+		for filePath, p := range g.waiting {
+			discovered = append(discovered, p)
+			delete(g.waiting, filePath)
+		}
+
+		g.registerDiscovered(discovered, replaces)
+	}
+
+	// Plugins which depend on removed or replaced plugins may not be
+	// satisfied anymore.
+	g.unloadUnsatisfied()
+
+	return nil
+}
+
+// registerDiscovered registers the discovered plugins together with all
+// loaded plugins. replaces contains the loaded plugins which are replaced
+// by a discovered plugin.
+func (g *GoPlug) registerDiscovered(discovered []*plugin, replaces map[*plugin]*plugin) {
+	// Check the dependencies together with all loaded plugins.
+	var candidates []*plugin
+	for _, p := range g.loadedPlugins() {
+		replaced := false
+		for _, old := range replaces {
+			if old == p {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			candidates = append(candidates, p)
+		}
+	}
+	candidates = append(candidates, discovered...)
+
+	// The loaded plugins come first, so only new plugins are refused.
+	candidates, errs := duplicateIDs(candidates)
+	for _, e := range errs {
+		log.Println(e.err)
+		g.setInactive(e.plugin.filePath, e.plugin, PluginDisabled, newFailure(e.plugin.filePath, e.plugin, PhaseValidation, e.err))
+	}
+
+	sorted, errs := sortByDependencies(candidates)
+	for _, e := range errs {
+		for _, d := range discovered {
+			if d == e.plugin {
+				log.Println(e.err)
+				g.setWaiting(d, e.err)
+			}
+		}
+	}
+
+	registered := make(map[*plugin]bool)
+	for _, p := range sorted {
+		isNew := false
+		for _, d := range discovered {
+			if d == p {
+				isNew = true
+				break
+			}
+		}
+		if !isNew {
+			continue
+		}
+
+		// The old plugin is already handled, even if the registration fails.
+		registered[p] = true
+
+		var err error
+		if old, ok := replaces[p]; ok {
+			err = g.replace(old, p)
+		} else {
+			err = g.register(p)
+		}
+
+		if err != nil {
+			g.setInactive(p.filePath, p, PluginDisabled, newFailure(p.filePath, p, PhaseRegistration, err))
+			log.Println(err)
+			continue
+		}
+		g.clearInactive(p.filePath)
+	}
+
+	// The old version of a plugin is removed even if the dependencies of the
+	// new one are not satisfied, as its binary does not exist anymore.
+	for _, p := range discovered {
+		if old, ok := replaces[p]; ok && !registered[p] {
+			g.logError(g.unload(old, ReasonReplaced))
+		}
+	}
+}
+
+// unloadUnsatisfied unloads all plugins whose dependencies are not
+// satisfied anymore, e.g. because a required plugin was removed.
+// They are registered again as soon as their dependencies are satisfied.
+func (g *GoPlug) unloadUnsatisfied() {
+	_, errs := sortByDependencies(g.loadedPlugins())
+	for _, e := range errs {
+		log.Println(e.err)
+		g.logError(g.unload(e.plugin, ReasonUnsatisfied))
+		g.setWaiting(e.plugin, e.err)
+	}
+}
+
+// setWaiting remembers a plugin whose dependencies are not satisfied.
+// Watch tries to register it again when other plugins are added or changed.
+func (g *GoPlug) setWaiting(p *plugin, err error) {
+	if g.waiting == nil {
+		g.waiting = make(map[string]*plugin)
+	}
+	g.waiting[p.filePath] = p
+
+	g.setInactive(p.filePath, p, PluginDisabled, newFailure(p.filePath, p, PhaseDependencies, err))
+}
+
+func (g *GoPlug) logError(err error) {
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// loadedPlugins returns all registered plugins.
+func (g *GoPlug) loadedPlugins() []*plugin {
+	var plugins []*plugin
+
+	g.oneShotPluginsMutex.Lock()
+	for _, p := range g.oneShotPlugins {
+		plugins = append(plugins, p)
+	}
+	g.oneShotPluginsMutex.Unlock()
+
+	g.listenerPluginsMutex.Lock()
+	for _, p := range g.listenerPlugins {
+		plugins = append(plugins, p)
+	}
+	g.listenerPluginsMutex.Unlock()
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].ID < plugins[j].ID
+	})
+	return plugins
+}
+
+// pluginByPath returns the registered plugin loaded from the file.
+// It returns nil if there is none.
+func (g *GoPlug) pluginByPath(filePath string) *plugin {
+	for _, p := range g.loadedPlugins() {
+		if p.filePath == filePath {
+			return p
+		}
+	}
+	return nil
+}
+
+// remove removes the plugin from GoPlug without calling the ReloadHost.
+// A running Listener plugin is stopped after it received all queued messages.
+func (g *GoPlug) remove(p *plugin, reason Reason) {
+	switch p.PluginType {
+	case OneShot:
+		g.oneShotPluginsMutex.Lock()
+		if g.oneShotPlugins[p.ID] == p {
+			delete(g.oneShotPlugins, p.ID)
+		}
+		g.oneShotPluginsMutex.Unlock()
+	case Listener:
+		g.listenerPluginsMutex.Lock()
+		if g.listenerPlugins[p.ID] == p {
+			delete(g.listenerPlugins, p.ID)
+		}
+		g.listenerPluginsMutex.Unlock()
+
+		g.unregisterFilters(p)
+		g.drain(p, reason)
+	}
+
+	g.notifyUnloaded(p, reason)
+}
+
+// unload removes the plugin and notifies the host if it implements
+// ReloadHost.
+func (g *GoPlug) unload(p *plugin, reason Reason) error {
+	g.remove(p, reason)
+
+	if reloadHost, ok := g.Host.(ReloadHost); ok && p.PluginType == OneShot {
+		return checkpoint.From(reloadHost.UnregisterOneShot(p.PluginInfo))
+	}
+	return nil
+}
+
+// replace registers the plugin instead of the old plugin.
+func (g *GoPlug) replace(old *plugin, p *plugin) error {
+	reloadHost, ok := g.Host.(ReloadHost)
+	if !ok || old.PluginType != OneShot || p.PluginType != OneShot ||
+		len(old.EntryPoints) > 0 || len(p.EntryPoints) > 0 {
+		err := g.unload(old, ReasonReplaced)
+		if err != nil {
+			return err
+		}
+		return g.register(p)
+	}
+
+	g.remove(old, ReasonReplaced)
+
+	err := reloadHost.ReregisterOneShot(old.PluginInfo, p.PluginInfo, g.oneShotAction(p.ID))
+	if err != nil {
+		// The old plugin is gone, so its action must not be used anymore.
+		g.logError(checkpoint.From(reloadHost.UnregisterOneShot(old.PluginInfo)))
+		return checkpoint.From(err)
+	}
+
+	g.oneShotPluginsMutex.Lock()
+	g.oneShotPlugins[p.ID] = p
+	g.oneShotPluginsMutex.Unlock()
+	return nil
+}
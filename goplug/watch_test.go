@@ -0,0 +1,188 @@
+package goplug
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// reloadRecordingHost records the calls of the ReloadHost.
+type reloadRecordingHost struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (h *reloadRecordingHost) record(format string, a ...interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.calls = append(h.calls, fmt.Sprintf(format, a...))
+}
+
+// takeCalls returns the recorded calls and forgets them.
+func (h *reloadRecordingHost) takeCalls() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	calls := h.calls
+	h.calls = nil
+	return calls
+}
+
+func (h *reloadRecordingHost) RegisterOneShot(info PluginInfo, action OnOneShot) error {
+	h.record("register %v %v", info.ID, info.Description)
+	return nil
+}
+
+func (h *reloadRecordingHost) UnregisterOneShot(info PluginInfo) error {
+	h.record("unregister %v %v", info.ID, info.Description)
+	return nil
+}
+
+func (h *reloadRecordingHost) ReregisterOneShot(old PluginInfo, info PluginInfo, action OnOneShot) error {
+	h.record("reregister %v %v -> %v", info.ID, old.Description, info.Description)
+	return nil
+}
+
+// writeDiscoveryScript writes a plugin script which returns the information
+// in discovery mode. Each discovery is counted in the file countFile.
+// If info is empty, the discovery fails.
+func writeDiscoveryScript(t *testing.T, folder string, name string, countFile string, info string) string {
+	t.Helper()
+
+	script := "echo discovered >> " + countFile + "\n"
+	if info == "" {
+		script += "exit 1"
+	} else {
+		script += "echo '" + info + "'"
+	}
+	return writeScript(t, folder, name, script)
+}
+
+// discoveryCount returns how often the plugin using the countFile was
+// discovered.
+func discoveryCount(t *testing.T, countFile string) int {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(countFile)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "discovered")
+}
+
+func TestRescan(t *testing.T) {
+	folder := t.TempDir()
+	counts := t.TempDir()
+	countA := filepath.Join(counts, "a")
+	countB := filepath.Join(counts, "b")
+	countBroken := filepath.Join(counts, "broken")
+
+	writeDiscoveryScript(t, folder, "a", countA, `{"id":"a","plugin_type":"one_shot","description":"v1"}`)
+
+	h := &reloadRecordingHost{}
+	g := &GoPlug{
+		PluginFolder: folder,
+		Host:         h,
+		Actions:      testActions{},
+	}
+	_, err := g.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown(context.Background())
+
+	// rescan has to return the calls of the host and the discovery counts.
+	rescan := func(t *testing.T, wantCalls []string, wantA, wantB, wantBroken int) {
+		t.Helper()
+		h.takeCalls()
+
+		err := g.rescan()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if calls := h.takeCalls(); !reflect.DeepEqual(calls, wantCalls) {
+			t.Errorf("host calls = %q, want %q", calls, wantCalls)
+		}
+		for countFile, want := range map[string]int{countA: wantA, countB: wantB, countBroken: wantBroken} {
+			if got := discoveryCount(t, countFile); got != want {
+				t.Errorf("%v discovered %v times, want %v", filepath.Base(countFile), got, want)
+			}
+		}
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		rescan(t, nil, 1, 0, 0)
+	})
+
+	t.Run("add", func(t *testing.T) {
+		writeDiscoveryScript(t, folder, "b", countB, `{"id":"b","plugin_type":"one_shot","description":"v1"}`)
+		writeDiscoveryScript(t, folder, "broken", countBroken, "")
+
+		rescan(t, []string{"register b v1"}, 1, 1, 1)
+
+		if g.pluginByPath(path.Join(folder, "b")) == nil {
+			t.Error("the added plugin was not registered")
+		}
+		if state := pluginState(g, path.Join(folder, "broken")); state != PluginFailed {
+			t.Errorf("state of the broken plugin = %v, want %v", state, PluginFailed)
+		}
+	})
+
+	t.Run("failed discovery is not retried", func(t *testing.T) {
+		rescan(t, nil, 1, 1, 1)
+	})
+
+	t.Run("change", func(t *testing.T) {
+		// The size changes, so the change is detected even if the
+		// modification time stays the same.
+		writeDiscoveryScript(t, folder, "a", countA, `{"id":"a","plugin_type":"one_shot","description":"v2 changed"}`)
+
+		rescan(t, []string{"reregister a v1 -> v2 changed"}, 2, 1, 1)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		err := os.Remove(filepath.Join(folder, "b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rescan(t, []string{"unregister b v1"}, 2, 1, 1)
+
+		if g.pluginByPath(path.Join(folder, "b")) != nil {
+			t.Error("the removed plugin is still registered")
+		}
+		if state := pluginState(g, path.Join(folder, "b")); state != "" {
+			t.Errorf("state of the removed plugin = %v, want none", state)
+		}
+	})
+
+	t.Run("failed discovery is retried after a change", func(t *testing.T) {
+		writeDiscoveryScript(t, folder, "broken", countBroken, `{"id":"fixed","plugin_type":"one_shot","description":"v1"}`)
+
+		rescan(t, []string{"register fixed v1"}, 2, 1, 2)
+
+		if state := pluginState(g, path.Join(folder, "broken")); state != PluginDiscovered {
+			t.Errorf("state of the fixed plugin = %v, want %v", state, PluginDiscovered)
+		}
+	})
+}
+
+// pluginState returns the state of the plugin file.
+// It is empty if GoPlug does not know the file.
+func pluginState(g *GoPlug, filePath string) PluginState {
+	for _, status := range g.Plugins() {
+		if status.Path == filePath {
+			return status.State
+		}
+	}
+	return ""
+}
@@ -1,6 +1,7 @@
 //go:generate go run . generate actions -m github.com/aligator/goplug/example/host --allow-structs --allow-pointers --allow-slices ./example/host
 //go:generate go build -o ./example/plugin-bin ./example/plugin
 //go:generate go build -o ./example/plugin-bin ./example/plugin2
+//go:generate go build -o ./example/plugin-bin ./example/listener
 
 package main
 
@@ -35,6 +36,7 @@ To avoid confusion for plugin-developers this option should be false.`)
 If "allow-structs" is enabled, these structs may include pointers and slices even if the respective option is disabled!
 Be aware that these slices are always copied.
 `)
+	apiVersion := pflag.String("api-version", generate.DefaultAPIVersion, "semver of the host API, it should be increased whenever the actions change")
 	pflag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage of goplug:")
 		fmt.Fprintln(os.Stderr, "goplug generate actions [ OPTION ]... { PROJECT_ROOT }")
@@ -69,6 +71,7 @@ Be aware that these slices are always copied.
 		AllowStructs:  *allowStructs,
 		AllowPointers: *allowPointers,
 		AllowSlices:   *allowSlices,
+		APIVersion:    *apiVersion,
 	}
 
 	fmt.Printf("Clean target directory %v\n", g.Out)